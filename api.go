@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spotify Web API base URL, overridable via EZSPOTIFY_API_BASE_URL for proxies and tests
+var apiBaseURL = "https://api.spotify.com/v1"
+
+// Path prefix rewrites for API URLs (EZSPOTIFY_ENDPOINT_OVERRIDES), longest match wins.
+// A replacement starting with http(s):// also replaces the base URL.
+var endpointOverrides = map[string]string{}
+
+// parseEndpointOverrides parses "/default/path=/replacement,..." pairs.
+func parseEndpointOverrides(value string) map[string]string {
+	overrides := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		from, to, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || !strings.HasPrefix(from, "/") || to == "" {
+			if entry != "" {
+				log.Printf("Ignoring invalid endpoint override %q\n", entry)
+			}
+			continue
+		}
+		overrides[strings.TrimSuffix(from, "/")] = strings.TrimSuffix(to, "/")
+	}
+	return overrides
+}
+
+// resolveEndpoint returns the URL for an API path under baseURL, applying the
+// longest matching endpoint override.
+func resolveEndpoint(baseURL, path string) string {
+	best := ""
+	for prefix := range endpointOverrides {
+		rest, matches := strings.CutPrefix(path, prefix)
+		if !matches || len(prefix) <= len(best) {
+			continue
+		}
+		// Only match whole path segments
+		if rest == "" || rest[0] == '/' || rest[0] == '?' {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return baseURL + path
+	}
+
+	replacement := endpointOverrides[best]
+	if !strings.HasPrefix(replacement, "http://") && !strings.HasPrefix(replacement, "https://") {
+		replacement = baseURL + replacement
+	}
+	return replacement + path[len(best):]
+}
+
+// Set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// User-Agent sent with every API request, overridable via EZSPOTIFY_USER_AGENT
+var userAgent = "ez_spotify/" + version
+
+// Verbose logging enabled with EZSPOTIFY_DEBUG
+var debug bool
+
+func debugf(format string, args ...any) {
+	if debug {
+		log.Printf("[debug] "+format+"\n", args...)
+	}
+}
+
+// Matches error_description="..." in a WWW-Authenticate challenge
+var authErrorPattern = regexp.MustCompile(`error_description="([^"]*)"`)
+
+// doRequest sends req and turns any non-2xx response into an error. The caller
+// must close the body of a successful response.
+func doRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := doWithRetry(client, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && (req.Body == nil || req.GetBody != nil) {
+		// The saved token may have been revoked or rotated elsewhere: refresh and retry once
+		resp.Body.Close()
+		if err := refreshOnUnauthorized(); err != nil {
+			return nil, fmt.Errorf("re-authentication required, delete %s and restart: %w", tokenFile, err)
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		if resp, err = doWithRetry(client, req); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("re-authentication required, delete %s and restart: %w", tokenFile, err)
+		}
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusAccepted {
+		debugf("%s %s accepted (202), the device will apply it shortly", req.Method, req.URL.Path)
+	}
+	return resp, nil
+}
+
+// doRequestOnce sends req under ctx a single time, without doRequest's 429
+// retry or 401 refresh, for calls that must finish by ctx's deadline. Non-2xx
+// responses become errors as in doRequest.
+func doRequestOnce(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", userAgent)
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	// The oauth2 transport refreshes an expired token without ctx, so wait on
+	// ctx here rather than trusting client.Do to return in time
+	done := make(chan result, 1)
+	go func() {
+		resp, err := client.Do(req)
+		done <- result{resp, err}
+	}()
+
+	var resp *http.Response
+	select {
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.resp != nil {
+				r.resp.Body.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		resp = r.resp
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Token refresh doRequest runs on a 401 before retrying, replaced in tests
+var refreshOnUnauthorized = forceTokenRefresh
+
+// Rate-limit handling in doWithRetry
+const (
+	maxRateLimitRetries = 3
+	maxRetryAfter       = 30 * time.Second
+)
+
+// doWithRetry sends req, retrying up to maxRateLimitRetries times on 429 after
+// the Retry-After delay (capped at maxRetryAfter). The final response is
+// returned as-is, whatever its status.
+func doWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= maxRateLimitRetries {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		delay := time.Second
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			delay = time.Duration(seconds) * time.Second
+		}
+		delay = min(delay, maxRetryAfter)
+		resp.Body.Close()
+
+		log.Printf("Rate limited on %s, retrying in %s (%d/%d)\n", req.URL.Path, delay, attempt+1, maxRateLimitRetries)
+		time.Sleep(delay)
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// SpotifyClient sends Web API requests for paths under baseURL through
+// doRequest, so headers, retries, token refresh and error decoding live in one
+// place. Tests point baseURL at a mock server.
+type SpotifyClient struct {
+	http    *http.Client
+	baseURL string
+}
+
+// newSpotifyClient returns a client for apiBaseURL sending through httpClient.
+func newSpotifyClient(httpClient *http.Client) *SpotifyClient {
+	return &SpotifyClient{http: httpClient, baseURL: apiBaseURL}
+}
+
+// request sends a request for path, relative to baseURL. The caller must close
+// the body of a successful response.
+func (c *SpotifyClient) request(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, resolveEndpoint(c.baseURL, path), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return doRequest(c.http, req)
+}
+
+// callOnce sends a bodiless request for path through doRequestOnce, giving up
+// when ctx is done.
+func (c *SpotifyClient) callOnce(ctx context.Context, method, path string) error {
+	req, err := http.NewRequest(method, resolveEndpoint(c.baseURL, path), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doRequestOnce(ctx, c.http, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *SpotifyClient) get(path string) (*http.Response, error) {
+	return c.request("GET", path, nil)
+}
+
+// call is request for calls whose response body isn't needed.
+func (c *SpotifyClient) call(method, path string, body io.Reader) error {
+	resp, err := c.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *SpotifyClient) put(path string, body io.Reader) error {
+	return c.call("PUT", path, body)
+}
+
+func (c *SpotifyClient) post(path string, body io.Reader) error {
+	return c.call("POST", path, body)
+}
+
+// SpotifyError is returned for any non-2xx response from the Web API or the
+// accounts service.
+type SpotifyError struct {
+	Method     string
+	Endpoint   string // request path
+	StatusCode int
+	Code       string // reason or error code from the body, e.g. PREMIUM_REQUIRED or invalid_grant
+	Message    string
+}
+
+func (e *SpotifyError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Endpoint, e.StatusCode, e.Message)
+}
+
+// Is matches a target SpotifyError on its non-zero fields, so
+// errors.Is(err, &SpotifyError{StatusCode: 404}) matches any 404.
+func (e *SpotifyError) Is(target error) bool {
+	t, ok := target.(*SpotifyError)
+	if !ok {
+		return false
+	}
+	return (t.StatusCode == 0 || t.StatusCode == e.StatusCode) &&
+		(t.Code == "" || t.Code == e.Code) &&
+		(t.Endpoint == "" || t.Endpoint == e.Endpoint) &&
+		(t.Method == "" || t.Method == e.Method)
+}
+
+// checkResponse returns a *SpotifyError for a non-2xx response, using the
+// message from Spotify's error body or WWW-Authenticate header when present,
+// and otherwise the status text with a snippet of the body.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	code, message := parseError(body)
+	if message == "" {
+		if match := authErrorPattern.FindStringSubmatch(resp.Header.Get("WWW-Authenticate")); match != nil {
+			message = match[1]
+		}
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+		if snippet := bodySnippet(body); snippet != "" {
+			message += ": " + snippet
+		}
+	}
+	if resp.StatusCode == 403 && strings.Contains(strings.ToLower(message), "scope") {
+		message += fmt.Sprintf(" (delete %s and re-authenticate to grant new permissions)", tokenFile)
+	}
+
+	return &SpotifyError{
+		Method:     resp.Request.Method,
+		Endpoint:   resp.Request.URL.Path,
+		StatusCode: resp.StatusCode,
+		Code:       code,
+		Message:    message,
+	}
+}
+
+// Longest part of an unrecognized error body included in the message
+const errorSnippetLength = 200
+
+// bodySnippet returns the start of body on a single line, for error bodies
+// that aren't Spotify's JSON format, such as an HTML page from a proxy.
+func bodySnippet(body []byte) string {
+	snippet := strings.Join(strings.Fields(string(body)), " ")
+	if len(snippet) > errorSnippetLength {
+		snippet = strings.ToValidUTF8(snippet[:errorSnippetLength], "") + "..."
+	}
+	return snippet
+}
+
+// parseError extracts the code and message from a Web API error body
+// ({"error":{"reason":...,"message":...}}) or an accounts error body
+// ({"error":...,"error_description":...}).
+func parseError(body []byte) (code, message string) {
+	var apiError struct {
+		Error struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &apiError) == nil && apiError.Error.Message != "" {
+		return apiError.Error.Reason, apiError.Error.Message
+	}
+
+	var authError struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &authError) == nil && authError.Description != "" {
+		return authError.Error, authError.Description
+	}
+	return "", ""
+}
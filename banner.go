@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// printShortcuts lists the active key bindings, ordered by key.
+func printShortcuts() {
+	keys := make([]rune, 0, len(shortcuts))
+	for key := range shortcuts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	fmt.Println("Available shortcuts:")
+	for _, key := range keys {
+		shortcut, exists := actions[shortcuts[key]]
+		if !exists {
+			continue
+		}
+		fmt.Printf("  [%s] - %s\n", bindingLabel(key), shortcut.Name)
+	}
+	if quitRequiresHold {
+		fmt.Println("  [q/Esc twice, Ctrl-C] - Quit")
+	} else {
+		fmt.Println("  [q/Esc/Ctrl-C] - Quit")
+	}
+	fmt.Println("  Media keys (Play/Pause, Next, Previous) are also supported")
+	fmt.Println()
+}
+
+// enabledFeatures lists the optional behaviours switched on in the current configuration.
+func enabledFeatures() []string {
+	var features []string
+	if activeProfile != "" {
+		features = append(features, "profile "+activeProfile)
+	}
+	if pollInterval > 0 {
+		state := "every " + pollInterval.String()
+		if pollerPaused.Load() {
+			state = "paused"
+		}
+		features = append(features, "polling ("+state+")")
+	}
+	if nowPlayingAfterAction {
+		features = append(features, "now playing after actions")
+	}
+	if notifications {
+		features = append(features, "notifications")
+	}
+	if ttsAnnounce {
+		features = append(features, "track announcements")
+	}
+	if lowLatency {
+		features = append(features, "low latency")
+	}
+	if wakeDevice {
+		features = append(features, "wake device")
+	}
+	if focusMode.Load() {
+		features = append(features, "focus mode")
+	}
+	if privacyMode.Load() {
+		features = append(features, "privacy mode")
+	}
+	if maxVolume < 100 {
+		features = append(features, fmt.Sprintf("volume cap %d%%", maxVolume))
+	}
+	if idleExit > 0 {
+		features = append(features, "idle exit after "+idleExit.String())
+	}
+	if pauseOnExit {
+		features = append(features, "pause on exit")
+	}
+	if sessionSummary {
+		features = append(features, "session summary")
+	}
+	if soundFeedback {
+		features = append(features, "sound feedback")
+	}
+	return features
+}
+
+// printBanner clears the screen and reprints the version, enabled features and bindings.
+func printBanner(_ *SpotifyClient) error {
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("🎵 Spotify Controller %s\n", version)
+
+	if features := enabledFeatures(); len(features) > 0 {
+		fmt.Printf("Enabled: %s\n", strings.Join(features, ", "))
+	}
+	fmt.Printf("Volume step: %d%%\n\n", volumeStep)
+
+	printShortcuts()
+	return nil
+}
+
+func showHelp(_ *SpotifyClient) error {
+	printShortcuts()
+	return nil
+}
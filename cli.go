@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Short names for one-shot commands, e.g. "vu" for "volume +10"
+var cliAliases map[string][]string
+
+// parseAliases parses "alias=command args,alias=command" pairs, skipping malformed entries.
+func parseAliases(value string) map[string][]string {
+	aliases := map[string][]string{}
+	for _, entry := range strings.Split(value, ",") {
+		name, command, found := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		fields := strings.Fields(command)
+		if !found || name == "" || len(fields) == 0 {
+			if strings.TrimSpace(entry) != "" {
+				log.Printf("Ignoring invalid alias %q\n", entry)
+			}
+			continue
+		}
+		aliases[name] = fields
+	}
+	return aliases
+}
+
+// runCommand runs one command from the command line: an action name, an
+// alias, or "volume" with a signed delta (+10, -5) or an absolute level (40).
+func runCommand(client *SpotifyClient, args []string) error {
+	if alias, ok := cliAliases[args[0]]; ok {
+		args = append(append([]string{}, alias...), args[1:]...)
+	}
+
+	name := args[0]
+	if name == "volume" {
+		if len(args) != 2 {
+			return fmt.Errorf("usage: volume +N|-N|N")
+		}
+		return setVolumeCommand(client, args[1])
+	}
+	if _, exists := actions[name]; !exists {
+		return fmt.Errorf("unknown command %q; valid commands: %s", name, strings.Join(commandNames(), ", "))
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("%s takes no arguments", name)
+	}
+	return runAction(client, name, false)
+}
+
+// setVolumeCommand applies a signed value as a change and an unsigned one as the new level.
+func setVolumeCommand(client *SpotifyClient, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid volume %q", value)
+	}
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		return adjustVolume(client, "", n)
+	}
+	return setVolume(client, n)
+}
+
+// commandNames lists every action, alias and built-in command, sorted.
+func commandNames() []string {
+	names := []string{"volume"}
+	for name := range actions {
+		// "volume N" and "volume ±N" cover the generated volume actions
+		if !isVolumeLevelAction(name) {
+			names = append(names, name)
+		}
+	}
+	for name := range cliAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config is the optional JSON config file, an alternative to EZSPOTIFY_* variables.
+// Environment variables (and the active profile) take precedence over it.
+type Config struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	LocalPort    string `json:"local_port"`
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	VolumeStep   int    `json:"volume_step"`
+	// Key bindings by action name, e.g. {"play-pause": " ", "next": "n"}
+	Keys map[string]string `json:"keys"`
+	// Any other setting by variable name, e.g. {"EZSPOTIFY_POLL_INTERVAL": "10s"}
+	Env map[string]string `json:"env"`
+}
+
+// Values from the config file keyed by the environment variable they stand in for
+var configValues = map[string]string{}
+
+// configDir returns $XDG_CONFIG_HOME/ez_spotify, falling back to ~/.config/ez_spotify.
+func configDir() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "ez_spotify"), nil
+}
+
+// loadConfig reads the file named by EZSPOTIFY_CONFIG, or config.json in the
+// config directory. A missing default file yields an empty Config.
+func loadConfig() (*Config, error) {
+	path := os.Getenv("EZSPOTIFY_CONFIG")
+	explicit := path != ""
+	if !explicit {
+		dir, err := configDir()
+		if err != nil {
+			return &Config{}, nil
+		}
+		path = filepath.Join(dir, "config.json")
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// values flattens the config into the environment variables it represents.
+func (c *Config) values() map[string]string {
+	values := map[string]string{}
+	for key, value := range c.Env {
+		values[key] = value
+	}
+	set := func(key, value string) {
+		if value != "" {
+			values[key] = value
+		}
+	}
+	set("EZSPOTIFY_CLIENT_ID", c.ClientID)
+	set("EZSPOTIFY_CLIENT_SECRET", c.ClientSecret)
+	set("EZSPOTIFY_LOCAL_PORT", c.LocalPort)
+	set("EZSPOTIFY_CERT_FILE", c.CertFile)
+	set("EZSPOTIFY_KEY_FILE", c.KeyFile)
+	if c.VolumeStep != 0 {
+		values["EZSPOTIFY_VOLUME_STEP"] = strconv.Itoa(c.VolumeStep)
+	}
+	for action, key := range c.Keys {
+		set("EZSPOTIFY_KEY_"+strings.ToUpper(strings.ReplaceAll(action, "-", "_")), key)
+	}
+	return values
+}
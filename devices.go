@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Device struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Type             string `json:"type"`
+	IsActive         bool   `json:"is_active"`
+	IsPrivateSession bool   `json:"is_private_session"`
+	IsRestricted     bool   `json:"is_restricted"`
+	SupportsVolume   bool   `json:"supports_volume"`
+	VolumePercent    int    `json:"volume_percent"`
+}
+
+// Send a state request before the first command so sleeping Connect devices wake up
+var (
+	wakeDevice     bool
+	wakeDeviceOnce sync.Once
+)
+
+const (
+	// Delay after the wake request before sending the real command
+	wakeDeviceDelay = 500 * time.Millisecond
+	// Delay after activating a device before querying it
+	transferSettleDelay = 500 * time.Millisecond
+)
+
+// wakeActiveDevice pings the player once per session if EZSPOTIFY_WAKE_DEVICE is enabled.
+func wakeActiveDevice(client *SpotifyClient) {
+	if !wakeDevice {
+		return
+	}
+	wakeDeviceOnce.Do(func() {
+		// Not getPlayerState, which would use up a prefetch meant for the action
+		if _, err := fetchPlayerState(client); err != nil {
+			return
+		}
+		time.Sleep(wakeDeviceDelay)
+	})
+}
+
+// Starting volume applied after transferring to a device, keyed by lowercase device name
+var deviceVolumes map[string]int
+
+// parseDeviceVolumes parses "Name=volume,Name=volume" pairs, skipping malformed entries.
+func parseDeviceVolumes(value string) map[string]int {
+	volumes := map[string]int{}
+	for _, entry := range strings.Split(value, ",") {
+		name, volume, found := strings.Cut(entry, "=")
+		if !found {
+			continue
+		}
+		percent, err := strconv.Atoi(strings.TrimSpace(volume))
+		if err != nil || percent < 0 || percent > 100 {
+			log.Printf("Ignoring invalid device volume %q\n", entry)
+			continue
+		}
+		volumes[strings.ToLower(strings.TrimSpace(name))] = percent
+	}
+	return volumes
+}
+
+// Device name preferred by ensureActiveDevice over prompting
+var defaultDevice string
+
+// findDeviceByName returns the device whose name matches case-insensitively.
+func findDeviceByName(devices []Device, name string) (Device, bool) {
+	for _, d := range devices {
+		if strings.EqualFold(d.Name, name) {
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// Device adjusted by the target volume keys, leaving the active device alone
+var targetDevice string
+
+func targetVolumeUp(client *SpotifyClient) error {
+	return adjustTargetVolume(client, volumeStep)
+}
+
+func targetVolumeDown(client *SpotifyClient) error {
+	return adjustTargetVolume(client, -volumeStep)
+}
+
+// adjustTargetVolume changes the volume of EZSPOTIFY_TARGET_DEVICE by delta.
+func adjustTargetVolume(client *SpotifyClient, delta int) error {
+	if targetDevice == "" {
+		return fmt.Errorf("no target device configured (set EZSPOTIFY_TARGET_DEVICE)")
+	}
+	devices, err := findDevices(client)
+	if err != nil {
+		return err
+	}
+	device, found := findDeviceByName(devices, targetDevice)
+	if !found {
+		return fmt.Errorf("target device %s is not available", targetDevice)
+	}
+	return adjustVolume(client, device.ID, delta)
+}
+
+func listDevices(client *SpotifyClient) ([]Device, error) {
+	resp, err := client.get("/me/player/devices")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Devices []Device `json:"devices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Devices, nil
+}
+
+// Attempts and delay for waiting out an empty device list right after Spotify starts
+var (
+	deviceRetries    = 3
+	deviceRetryDelay = time.Second
+)
+
+// findDevices is listDevices that retries a few times while the list comes back empty.
+func findDevices(client *SpotifyClient) ([]Device, error) {
+	for attempt := 1; ; attempt++ {
+		devices, err := listDevices(client)
+		if err != nil || len(devices) > 0 || attempt >= deviceRetries {
+			return devices, err
+		}
+		debugf("Device list empty, retrying (%d/%d)", attempt, deviceRetries-1)
+		time.Sleep(deviceRetryDelay)
+	}
+}
+
+// transferPlayback moves playback to the device, then applies its configured starting volume.
+func transferPlayback(client *SpotifyClient, device Device) error {
+	body, _ := json.Marshal(map[string][]string{"device_ids": {device.ID}})
+	if err := client.put("/me/player", bytes.NewReader(body)); err != nil {
+		return err
+	}
+
+	volume, exists := deviceVolumes[strings.ToLower(device.Name)]
+	if !exists {
+		return nil
+	}
+	volume = clampVolume(volume)
+
+	if err := putVolume(client, device.ID, volume); err != nil {
+		return err
+	}
+
+	fmt.Printf("Set %s volume to %d%%\n", device.Name, volume)
+	return nil
+}
+
+// chooseDevice prints the devices numbered and waits for the user to pick one.
+func chooseDevice(devices []Device) (Device, error) {
+	fmt.Println("Available devices:")
+	for i, d := range devices {
+		active := ""
+		if d.IsActive {
+			active = " (active)"
+		}
+		fmt.Printf("  [%d] %s - %s%s\n", i+1, d.Name, d.Type, active)
+	}
+
+	choice, err := readChoice(len(devices))
+	if err != nil {
+		return Device{}, err
+	}
+	return devices[choice-1], nil
+}
+
+// pickDevice lists available devices and transfers playback to the one chosen by number.
+func pickDevice(client *SpotifyClient) error {
+	devices, err := findDevices(client)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("no devices available - open Spotify on a device first")
+	}
+
+	device, err := chooseDevice(devices)
+	if errors.Is(err, errChoiceCancelled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := transferPlayback(client, device); err != nil {
+		return err
+	}
+	fmt.Printf("Playing on %s\n", device.Name)
+	return nil
+}
+
+// cycleDevice transfers playback to the device after the active one in the device list.
+func cycleDevice(client *SpotifyClient) error {
+	devices, err := findDevices(client)
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("no devices available - open Spotify on a device first")
+	}
+
+	next := 0
+	for i, d := range devices {
+		if d.IsActive {
+			next = (i + 1) % len(devices)
+			break
+		}
+	}
+	if devices[next].IsActive {
+		fmt.Printf("Only device: %s\n", devices[next].Name)
+		return nil
+	}
+
+	device := devices[next]
+	if err := transferPlayback(client, device); err != nil {
+		return err
+	}
+	fmt.Printf("Playing on %s (%d/%d)\n", device.Name, next+1, len(devices))
+	return nil
+}
+
+// ensureActiveDevice transfers playback to a device if none is active: the
+// default device, the only available one, or the user's pick when there are
+// several. Without a way to prompt it falls back to the first device listed.
+func ensureActiveDevice(client *SpotifyClient) error {
+	devices, err := findDevices(client)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if d.IsActive {
+			return nil
+		}
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("no devices available - open Spotify on a device first")
+	}
+
+	device, found := findDeviceByName(devices, defaultDevice)
+	if !found {
+		device = devices[0]
+	}
+	if !found && len(devices) > 1 && promptAllowed {
+		fmt.Println("No active device.")
+		device, err = chooseDevice(devices)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := transferPlayback(client, device); err != nil {
+		return err
+	}
+	fmt.Printf("Activated %s\n", device.Name)
+
+	// Give the device a moment to report itself as active
+	time.Sleep(transferSettleDelay)
+	return nil
+}
+
+// isNoActiveDevice reports whether err means there was no device to act on:
+// an empty player state, or a command rejected with NO_ACTIVE_DEVICE.
+func isNoActiveDevice(err error) bool {
+	return errors.Is(err, errNoActiveDevice) ||
+		errors.Is(err, &SpotifyError{StatusCode: http.StatusNotFound, Code: "NO_ACTIVE_DEVICE"})
+}
+
+// activeDeviceState returns the player state, first activating a device if the
+// reported one is missing or inactive.
+func activeDeviceState(client *SpotifyClient) (*playerState, error) {
+	state, err := getPlayerState(client)
+	if err == nil && state.Device.IsActive && state.Device.ID != "" {
+		return state, nil
+	}
+	if err != nil && !errors.Is(err, errNoActiveDevice) {
+		return nil, err
+	}
+
+	if err := ensureActiveDevice(client); err != nil {
+		return nil, err
+	}
+	return fetchPlayerState(client)
+}
+
+// showLoudnessInfo prints the active device's volume-related flags with guidance
+// on normalization, which the Web API neither reports nor controls.
+func showLoudnessInfo(client *SpotifyClient) error {
+	state, err := getPlayerState(client)
+	if err != nil {
+		return err
+	}
+
+	d := state.Device
+	fmt.Printf("Device: %s (%s)\n", d.Name, d.Type)
+	fmt.Printf("  Volume:          %d%%\n", d.VolumePercent)
+	fmt.Printf("  Remote volume:   %t\n", d.SupportsVolume)
+	fmt.Printf("  Restricted:      %t\n", d.IsRestricted)
+	fmt.Printf("  Private session: %t\n", d.IsPrivateSession)
+	fmt.Println("Normalization isn't exposed by the Web API. If loudness jumps between tracks,")
+	fmt.Println("enable Settings > Playback > Normalize volume in the Spotify app on this device.")
+	if !d.SupportsVolume || d.IsRestricted {
+		fmt.Println("This device manages its own volume, so volume keys may have no effect.")
+	}
+	return nil
+}
+
+// Substrings of player state keys that hint at streaming quality
+var qualityFieldHints = []string{"bitrate", "quality", "codec", "format"}
+
+// showQualityInfo prints the playback context and any quality-related fields the
+// player state happens to include. The Web API can't read or set streaming quality.
+func showQualityInfo(client *SpotifyClient) error {
+	resp, err := client.get("/me/player?additional_types=episode")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return errNoActiveDevice
+	}
+
+	var raw map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return err
+	}
+
+	device, _ := raw["device"].(map[string]any)
+	fmt.Printf("Device: %v (%v)\n", valueOr(device["name"], "unknown"), valueOr(device["type"], "unknown"))
+	fmt.Printf("Playing: %v\n", valueOr(raw["currently_playing_type"], "unknown"))
+
+	found := qualityFields("", raw)
+	if len(found) == 0 {
+		fmt.Println("No bitrate information reported. Streaming quality is set in the Spotify app")
+		fmt.Println("(Settings > Audio quality); lower it there on constrained networks.")
+		return nil
+	}
+	sort.Strings(found)
+	for _, field := range found {
+		fmt.Println("  " + field)
+	}
+	return nil
+}
+
+// qualityFields returns "path: value" for every key under v matching qualityFieldHints.
+func qualityFields(prefix string, v any) []string {
+	fields, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	var found []string
+	for key, value := range fields {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok {
+			found = append(found, qualityFields(path, nested)...)
+			continue
+		}
+		for _, hint := range qualityFieldHints {
+			if strings.Contains(strings.ToLower(key), hint) {
+				found = append(found, fmt.Sprintf("%s: %v", path, value))
+				break
+			}
+		}
+	}
+	return found
+}
+
+func valueOr(v any, fallback string) any {
+	if v == nil || v == "" {
+		return fallback
+	}
+	return v
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// startPlayback issues PUT /me/player/play with the given body (context_uri, uris, offset...).
+func startPlayback(client *SpotifyClient, body map[string]any) error {
+	data, _ := json.Marshal(body)
+	return client.put("/me/player/play", bytes.NewReader(data))
+}
+
+func setShuffle(client *SpotifyClient, enabled bool) error {
+	return client.put(fmt.Sprintf("/me/player/shuffle?state=%t", enabled), nil)
+}
+
+// toggleShuffle flips the shuffle state of the current playback.
+func toggleShuffle(client *SpotifyClient) error {
+	state, err := getPlayerState(client)
+	if err != nil {
+		return err
+	}
+
+	if err := setShuffle(client, !state.ShuffleState); err != nil {
+		return err
+	}
+	if state.ShuffleState {
+		fmt.Println("Shuffle: off")
+	} else {
+		fmt.Println("Shuffle: on")
+	}
+	return nil
+}
+
+// smartShuffle approximates Spotify's smart shuffle, which the public API doesn't
+// expose: it enables shuffle and plays recommendations seeded by the current track.
+func smartShuffle(client *SpotifyClient) error {
+	t, err := getCurrentTrack(client)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("nothing playing to seed recommendations")
+	}
+
+	resp, err := client.get("/recommendations?limit=50&seed_tracks=" + t.ID)
+	if err != nil {
+		return fmt.Errorf("recommendations unavailable for this app: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Tracks []track `json:"tracks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Tracks) == 0 {
+		return fmt.Errorf("no recommendations for %s", t.Name)
+	}
+
+	uris := []string{t.URI}
+	for _, rec := range result.Tracks {
+		uris = append(uris, rec.URI)
+	}
+
+	if err := startPlayback(client, map[string]any{"uris": uris}); err != nil {
+		return err
+	}
+	if err := setShuffle(client, true); err != nil {
+		return err
+	}
+
+	fmt.Printf("Smart shuffle: %d tracks like %s\n", len(result.Tracks), t.Name)
+	return nil
+}
+
+// playAlbum switches to playing the current track's album, continuing from the same track and position.
+func playAlbum(client *SpotifyClient) error {
+	state, err := getPlayerState(client)
+	if err != nil {
+		return err
+	}
+	t := state.Item
+	if t == nil {
+		return fmt.Errorf("nothing playing")
+	}
+	if t.Album == nil || t.Album.URI == "" {
+		return fmt.Errorf("%s isn't part of an album", t.Name)
+	}
+
+	// Offsetting by URI rather than track number holds up for multi-disc albums and compilations
+	err = startPlayback(client, map[string]any{
+		"context_uri": t.Album.URI,
+		"offset":      map[string]string{"uri": t.URI},
+		"position_ms": state.ProgressMs,
+	})
+	if err != nil {
+		return err
+	}
+
+	if t.Album.AlbumType == "single" && t.Album.TotalTracks <= 1 {
+		fmt.Printf("Playing single: %s\n", t.Album.Name)
+	} else {
+		fmt.Printf("Playing album: %s\n", t.Album.Name)
+	}
+	return nil
+}
+
+// playArtistTopTracks plays the top tracks of the current track's primary artist.
+func playArtistTopTracks(client *SpotifyClient) error {
+	t, err := getCurrentTrack(client)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("nothing playing")
+	}
+	if len(t.Artists) == 0 || t.Artists[0].ID == "" {
+		return fmt.Errorf("%s has no artist", t.Name)
+	}
+	artist := t.Artists[0]
+
+	resp, err := client.get("/artists/" + artist.ID + "/top-tracks?market=from_token")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Tracks []track `json:"tracks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Tracks) == 0 {
+		return fmt.Errorf("no top tracks for %s", artist.Name)
+	}
+
+	uris := make([]string, 0, len(result.Tracks))
+	for _, top := range result.Tracks {
+		uris = append(uris, top.URI)
+	}
+	if err := startPlayback(client, map[string]any{"uris": uris}); err != nil {
+		return err
+	}
+
+	fmt.Printf("Playing top tracks by %s\n", artist.Name)
+	return nil
+}
+
+// Playlist URIs alternated between by togglePlaylist
+var playlistToggleA, playlistToggleB string
+
+// togglePlaylist starts playlist B if playlist A is the current context, and
+// playlist A otherwise.
+func togglePlaylist(client *SpotifyClient) error {
+	if playlistToggleA == "" || playlistToggleB == "" {
+		return fmt.Errorf("set EZSPOTIFY_PLAYLIST_TOGGLE_A and EZSPOTIFY_PLAYLIST_TOGGLE_B")
+	}
+
+	state, err := getPlayerState(client)
+	if err != nil && !errors.Is(err, errNoActiveDevice) {
+		return err
+	}
+	uri := playlistToggleA
+	if state != nil && state.Context != nil && state.Context.URI == playlistToggleA {
+		uri = playlistToggleB
+	}
+
+	if err := startPlayback(client, map[string]any{"context_uri": uri}); err != nil {
+		return err
+	}
+	fmt.Printf("Playing playlist: %s\n", contextName(client, "playlist", uri))
+	return nil
+}
+
+// contextName looks up the name of an album, playlist, artist or show,
+// falling back to its URI.
+func contextName(client *SpotifyClient, kind, uri string) string {
+	endpoint := fmt.Sprintf("/%ss/%s", kind, idFromURI(uri))
+	if kind == "playlist" {
+		endpoint += "?fields=name"
+	}
+	resp, err := client.get(endpoint)
+	if err != nil {
+		return uri
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Name == "" {
+		return uri
+	}
+	return result.Name
+}
+
+// Copy the context's link when showing it with showContext
+var copyContextURL bool
+
+// showContext prints the album, playlist, artist or show playback was started
+// from, optionally copying its link.
+func showContext(client *SpotifyClient) error {
+	state, err := getPlayerState(client)
+	if err != nil {
+		return err
+	}
+	c := state.Context
+	if c == nil || c.URI == "" {
+		fmt.Println("Not playing from an album, playlist or artist")
+		return nil
+	}
+
+	fmt.Printf("Playing from %s: %s\n", c.Type, contextName(client, c.Type, c.URI))
+	link := c.ExternalURLs.Spotify
+	if link == "" {
+		link = fmt.Sprintf("https://open.spotify.com/%s/%s", c.Type, idFromURI(c.URI))
+	}
+	fmt.Printf("  %s\n", link)
+
+	if !copyContextURL {
+		return nil
+	}
+	if err := copyToClipboard(link); err != nil {
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+	fmt.Println("Copied link to the clipboard")
+	return nil
+}
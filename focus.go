@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Actions disabled in focus mode so skipping around isn't one key away
+var focusBlocked = map[string]bool{
+	"next":          true,
+	"prev":          true,
+	"show-queue":    true,
+	"smart-shuffle": true,
+	"artist-top":    true,
+	"play-album":    true,
+}
+
+// Focus mode, atomic since the media-key listener checks it without actionMu
+var focusMode atomic.Bool
+
+// Full bindings, restored when focus mode ends. Guarded by actionMu.
+var allShortcuts map[rune]string
+
+func toggleFocusMode(_ *SpotifyClient) error {
+	if focusMode.Load() {
+		focusMode.Store(false)
+		shortcuts = allShortcuts
+		fmt.Println("Focus mode: off")
+		return nil
+	}
+
+	focusMode.Store(true)
+	allShortcuts = shortcuts
+	shortcuts = focusedShortcuts(shortcuts)
+	fmt.Println("Focus mode: on (skip and discovery keys disabled)")
+	return nil
+}
+
+// focusedShortcuts returns the bindings with focus-blocked actions removed.
+func focusedShortcuts(all map[rune]string) map[rune]string {
+	focused := make(map[rune]string, len(all))
+	for key, name := range all {
+		if !focusBlocked[name] {
+			focused[key] = name
+		}
+	}
+	return focused
+}
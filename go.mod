@@ -7,9 +7,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/robotn/gohook v0.42.2
 	golang.org/x/oauth2 v0.32.0
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
 )
 
-require (
-	github.com/vcaesar/keycode v0.10.1 // indirect
-	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a // indirect
-)
+require github.com/vcaesar/keycode v0.10.1 // indirect
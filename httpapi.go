@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// Optional HTTP control API, enabled by EZSPOTIFY_HTTP_API_PORT
+var (
+	httpAPIPort  string
+	httpAPIBind  string
+	httpAPIToken string // Required as a Bearer token when set
+)
+
+// startHTTPAPI serves the control API in the background if a port is configured.
+func startHTTPAPI(client *SpotifyClient) {
+	if httpAPIPort == "" {
+		return
+	}
+	if httpAPIBind != "127.0.0.1" && httpAPIToken == "" {
+		log.Printf("HTTP API listening on %s without EZSPOTIFY_HTTP_API_TOKEN; anyone on the network can control playback\n", httpAPIBind)
+	}
+
+	mux := http.NewServeMux()
+	for path, name := range map[string]string{
+		"/play-pause": "play-pause",
+		"/next":       "next",
+		"/prev":       "prev",
+	} {
+		mux.HandleFunc("POST "+path, func(w http.ResponseWriter, r *http.Request) {
+			writeResult(w, runAction(client, name, false))
+		})
+	}
+	mux.HandleFunc("POST /volume", func(w http.ResponseWriter, r *http.Request) {
+		delta, err := strconv.Atoi(r.URL.Query().Get("delta"))
+		if err != nil {
+			http.Error(w, "delta must be an integer", http.StatusBadRequest)
+			return
+		}
+		writeResult(w, runAction(client, volumeDeltaName(delta), false))
+	})
+
+	server := &http.Server{
+		Addr:    net.JoinHostPort(httpAPIBind, httpAPIPort),
+		Handler: rejectBrowserRequests(requireAPIToken(mux)),
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Printf("HTTP API stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("HTTP API listening on %s\n", server.Addr)
+}
+
+// requireAPIToken rejects requests without the configured Bearer token.
+func requireAPIToken(next http.Handler) http.Handler {
+	if httpAPIToken == "" {
+		return next
+	}
+	want := []byte("Bearer " + httpAPIToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectBrowserRequests refuses requests carrying an Origin header. Browsers
+// send one with every cross-site POST, so without this any open web page could
+// control playback; scripts and curl don't send it.
+func rejectBrowserRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Origin") != "" {
+			http.Error(w, "browser requests are not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+// Privacy mode suppresses every outbound now-playing integration (notifications,
+// announcements, ...) while playback control keeps working. Integrations must
+// check sharingNowPlaying before publishing anything.
+var privacyMode atomic.Bool
+
+func sharingNowPlaying() bool {
+	return !privacyMode.Load()
+}
+
+func togglePrivacyMode(_ *SpotifyClient) error {
+	enabled := !privacyMode.Load()
+	privacyMode.Store(enabled)
+	if enabled {
+		fmt.Println("Privacy mode: on (now-playing integrations paused)")
+	} else {
+		fmt.Println("Privacy mode: off")
+	}
+	return nil
+}
+
+// Speak each new track through the OS text-to-speech, enabled by EZSPOTIFY_TTS_ANNOUNCE
+var ttsAnnounce bool
+
+// announceTracks speaks the title and artist of each track the poller reports.
+func announceTracks(events <-chan PlayerEvent) {
+	for ev := range events {
+		if !ttsAnnounce || ev.Type != TrackChanged || ev.State.Item == nil || !sharingNowPlaying() {
+			continue
+		}
+		t := ev.State.Item
+		text := t.Name
+		if artist := t.artistName(); artist != "" {
+			text += " by " + artist
+		}
+		if err := speak(text); err != nil {
+			log.Printf("Track announcement failed: %v\n", err)
+		}
+	}
+}
+
+// speak starts the platform's text-to-speech command without waiting for it to finish.
+func speak(text string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("say", text)
+	case "windows":
+		script := "Add-Type -AssemblyName System.Speech; " +
+			"(New-Object System.Speech.Synthesis.SpeechSynthesizer).Speak('" + strings.ReplaceAll(text, "'", "''") + "')"
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		cmd = exec.Command("espeak", text)
+	}
+
+	return startDetached(cmd)
+}
+
+// startDetached starts cmd and reaps it in the background.
+func startDetached(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// Desktop notifications for track changes and playback toggles, enabled by EZSPOTIFY_NOTIFICATIONS
+var notifications bool
+
+// notify shows a desktop notification if enabled and not in privacy mode.
+// Failures are only logged at debug level, as not every desktop has a notifier.
+func notify(title, body string) {
+	if !notifications || !sharingNowPlaying() {
+		return
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "windows":
+		script := "[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null; " +
+			"$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02); " +
+			"$text = $xml.GetElementsByTagName('text'); " +
+			"$text.Item(0).InnerText = '" + strings.ReplaceAll(title, "'", "''") + "'; " +
+			"$text.Item(1).InnerText = '" + strings.ReplaceAll(body, "'", "''") + "'; " +
+			"[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('ez_spotify').Show([Windows.UI.Notifications.ToastNotification]::new($xml))"
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=ez_spotify", title, body)
+	}
+
+	if err := startDetached(cmd); err != nil {
+		debugf("Notification failed: %v", err)
+	}
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// notifyCurrentTrack shows the playing track in a notification titled title.
+func notifyCurrentTrack(client *SpotifyClient, title string) {
+	if !notifications || !sharingNowPlaying() {
+		return
+	}
+	t, err := getCurrentTrack(client)
+	if err != nil || t == nil {
+		debugf("No track to notify about: %v", err)
+		return
+	}
+	notify(title, trackSummary(t))
+}
+
+// trackSummary returns "Title — Artist", or just the title without an artist.
+func trackSummary(t *track) string {
+	if artist := t.artistName(); artist != "" {
+		return t.Name + " — " + artist
+	}
+	return t.Name
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/eiannone/keyboard"
+)
+
+// Named special keys usable in EZSPOTIFY_KEY_MAP
+var keyNames = map[string]keyboard.Key{
+	"space":       keyboard.KeySpace,
+	"tab":         keyboard.KeyTab,
+	"enter":       keyboard.KeyEnter,
+	"backspace":   keyboard.KeyBackspace2,
+	"insert":      keyboard.KeyInsert,
+	"delete":      keyboard.KeyDelete,
+	"home":        keyboard.KeyHome,
+	"end":         keyboard.KeyEnd,
+	"pgup":        keyboard.KeyPgup,
+	"pgdn":        keyboard.KeyPgdn,
+	"arrow-up":    keyboard.KeyArrowUp,
+	"arrow-down":  keyboard.KeyArrowDown,
+	"arrow-left":  keyboard.KeyArrowLeft,
+	"arrow-right": keyboard.KeyArrowRight,
+	"f1":          keyboard.KeyF1,
+	"f2":          keyboard.KeyF2,
+	"f3":          keyboard.KeyF3,
+	"f4":          keyboard.KeyF4,
+	"f5":          keyboard.KeyF5,
+	"f6":          keyboard.KeyF6,
+	"f7":          keyboard.KeyF7,
+	"f8":          keyboard.KeyF8,
+	"f9":          keyboard.KeyF9,
+	"f10":         keyboard.KeyF10,
+	"f11":         keyboard.KeyF11,
+	"f12":         keyboard.KeyF12,
+}
+
+// Runes substituted for keys the terminal reports as key codes rather than
+// characters. Space, Tab and Enter arrive this way on most terminals.
+var keyRunes = defaultKeyRunes()
+
+func defaultKeyRunes() map[keyboard.Key]rune {
+	return map[keyboard.Key]rune{
+		keyboard.KeySpace: ' ',
+		keyboard.KeyTab:   '\t',
+		keyboard.KeyEnter: '\r',
+	}
+}
+
+// parseKeyMap applies "name:char" overrides such as "arrow-right:n,arrow-left:p" to keyRunes.
+func parseKeyMap(value string) {
+	for _, entry := range strings.Split(value, ",") {
+		name, char, found := strings.Cut(strings.TrimSpace(entry), ":")
+		key, known := keyNames[strings.ToLower(name)]
+		if !found || !known || len(char) == 0 {
+			if entry != "" {
+				log.Printf("Ignoring invalid key mapping %q\n", entry)
+			}
+			continue
+		}
+		keyRunes[key] = rune(char[0])
+	}
+}
+
+// mappedKeyName returns the name of a special key EZSPOTIFY_KEY_MAP turned into char.
+func mappedKeyName(char rune) (string, bool) {
+	defaults := defaultKeyRunes()
+	for name, key := range keyNames {
+		if r, mapped := keyRunes[key]; mapped && r == char && defaults[key] != char {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// normalizeKey returns the rune a key press should be matched against in shortcuts.
+func normalizeKey(char rune, key keyboard.Key) rune {
+	if char != 0 {
+		return char
+	}
+	return keyRunes[key]
+}
+
+// Display names for runes that don't print as themselves
+var runeLabels = map[rune]string{
+	' ':  "Space",
+	'\t': "Tab",
+	'\r': "Enter",
+	'\n': "Enter",
+	27:   "Esc",
+	127:  "Backspace",
+}
+
+// keyLabel renders a bound rune for display, e.g. "Space", "Ctrl-A" or "n".
+func keyLabel(r rune) string {
+	if label, ok := runeLabels[r]; ok {
+		return label
+	}
+	if r > 0 && r < ' ' {
+		return fmt.Sprintf("Ctrl-%c", r+'@')
+	}
+	return string(r)
+}
+
+// bindingLabel renders a bound rune along with any named keys EZSPOTIFY_KEY_MAP
+// points at it, e.g. "n/arrow-right".
+func bindingLabel(r rune) string {
+	defaults := defaultKeyRunes()
+	var names []string
+	for name, key := range keyNames {
+		if keyRunes[key] == r && defaults[key] != r {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(append([]string{keyLabel(r)}, names...), "/")
+}
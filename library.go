@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Saved-in-library lookups keyed by track ID, cleared on track change by the
+// poller and by printNowPlaying, which works with polling off
+var (
+	savedTracks   = map[string]bool{}
+	savedTracksMu sync.Mutex
+	shownTrackID  string // Last track printNowPlaying showed, guarded by savedTracksMu
+)
+
+// Tracks liked during this session, in order, for exportLikedTracks
+var (
+	sessionLiked    []track
+	likedExportFile string
+)
+
+func clearSavedTracks() {
+	savedTracksMu.Lock()
+	defer savedTracksMu.Unlock()
+	savedTracks = map[string]bool{}
+}
+
+// trackShown clears the cache when id isn't the track shown last, so a like or
+// unlike from another client shows up once the next track plays.
+func trackShown(id string) {
+	savedTracksMu.Lock()
+	defer savedTracksMu.Unlock()
+	if id != shownTrackID {
+		shownTrackID = id
+		savedTracks = map[string]bool{}
+	}
+}
+
+func setTrackSaved(id string, saved bool) {
+	savedTracksMu.Lock()
+	defer savedTracksMu.Unlock()
+	savedTracks[id] = saved
+}
+
+// isTrackSaved reports whether the track is in the user's library, using the cache when possible.
+func isTrackSaved(client *SpotifyClient, id string) (bool, error) {
+	savedTracksMu.Lock()
+	saved, cached := savedTracks[id]
+	savedTracksMu.Unlock()
+	if cached {
+		return saved, nil
+	}
+
+	resp, err := client.get("/me/tracks/contains?ids=" + id)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var result []bool
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	if len(result) == 0 {
+		return false, fmt.Errorf("empty library lookup response")
+	}
+
+	setTrackSaved(id, result[0])
+	return result[0], nil
+}
+
+// toggleLike saves the current track to the library, or removes it if already saved.
+func toggleLike(client *SpotifyClient) error {
+	t, err := getCurrentTrack(client)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("nothing playing")
+	}
+	if t.Type == "episode" {
+		return fmt.Errorf("episodes can't be liked as tracks")
+	}
+
+	saved, err := isTrackSaved(client, t.ID)
+	if err != nil {
+		return err
+	}
+
+	method := "PUT"
+	if saved {
+		method = "DELETE"
+	}
+
+	if err := client.call(method, "/me/tracks?ids="+t.ID, nil); err != nil {
+		return err
+	}
+
+	setTrackSaved(t.ID, !saved)
+	recordSessionLike(*t, !saved)
+	if saved {
+		fmt.Printf("Removed from library: %s\n", t.Name)
+	} else {
+		fmt.Printf("♥ Saved to library: %s\n", t.Name)
+	}
+	return nil
+}
+
+// saveCurrentTrack saves the current track to the library; unlike toggleLike
+// it never removes a saved track.
+func saveCurrentTrack(client *SpotifyClient) error {
+	t, err := getCurrentTrack(client)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("nothing playing")
+	}
+	if t.Type == "episode" {
+		return fmt.Errorf("episodes can't be saved as tracks")
+	}
+
+	err = client.put("/me/tracks?ids="+t.ID, nil)
+	if errors.Is(err, &SpotifyError{StatusCode: http.StatusForbidden}) {
+		return fmt.Errorf("saving tracks needs the user-library-modify permission; delete %s and restart to re-authenticate: %w", tokenFile, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	setTrackSaved(t.ID, true)
+	recordSessionLike(*t, true)
+	fmt.Printf("♥ Saved to library: %s\n", t.Name)
+	return nil
+}
+
+// Playlist addToPlaylist appends to, from EZSPOTIFY_TARGET_PLAYLIST_ID
+var targetPlaylistID string
+
+// addToPlaylist appends the current track to the configured playlist.
+func addToPlaylist(client *SpotifyClient) error {
+	if targetPlaylistID == "" {
+		log.Println("Add to playlist skipped: EZSPOTIFY_TARGET_PLAYLIST_ID is not set")
+		return nil
+	}
+
+	t, err := getCurrentTrack(client)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("nothing playing")
+	}
+
+	body, _ := json.Marshal(map[string][]string{"uris": {t.URI}})
+	err = client.post("/playlists/"+idFromURI(targetPlaylistID)+"/tracks", bytes.NewReader(body))
+	if errors.Is(err, &SpotifyError{StatusCode: http.StatusForbidden}) {
+		return fmt.Errorf("adding to playlists needs the playlist-modify permissions; delete %s and restart to re-authenticate: %w", tokenFile, err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added to playlist: %s\n", t.Name)
+	return nil
+}
+
+// recordSessionLike adds or removes the track from the session's liked list.
+func recordSessionLike(t track, liked bool) {
+	for i, existing := range sessionLiked {
+		if existing.ID == t.ID {
+			sessionLiked = append(sessionLiked[:i], sessionLiked[i+1:]...)
+			break
+		}
+	}
+	if liked {
+		sessionLiked = append(sessionLiked, t)
+	}
+}
+
+// exportLikedTracks writes the URLs of tracks liked this session to
+// EZSPOTIFY_LIKED_EXPORT_FILE, or copies them to the clipboard if unset.
+func exportLikedTracks(_ *SpotifyClient) error {
+	if len(sessionLiked) == 0 {
+		fmt.Println("No tracks liked this session")
+		return nil
+	}
+
+	var list strings.Builder
+	for _, t := range sessionLiked {
+		list.WriteString("https://open.spotify.com/track/" + t.ID + "\n")
+	}
+
+	if likedExportFile != "" {
+		if err := os.WriteFile(likedExportFile, []byte(list.String()), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %d liked tracks to %s\n", len(sessionLiked), likedExportFile)
+		return nil
+	}
+
+	if err := copyToClipboard(list.String()); err != nil {
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+	fmt.Printf("Copied %d liked tracks to the clipboard\n", len(sessionLiked))
+	return nil
+}
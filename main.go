@@ -2,15 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/eiannone/keyboard"
@@ -28,19 +39,73 @@ var (
 	certFile     string
 	keyFile      string
 	redirectURL  string
-	tokenFile    = "spotify_token.json"
+	tokenFile    string
+	autoClose    bool
+	// Quit only on a second q/Esc within quitConfirmWindow
+	quitRequiresHold bool
+	authCodeFile     string
+	authTimeout      = 5 * time.Minute
+	pauseOnExit      bool
 )
 
-// Keyboard shortcuts configuration - loaded from env
-var shortcuts map[rune]ShortcutAction
+// Keyboard shortcuts configuration - loaded from env, maps keys to action names.
+// Guarded by actionMu, since actions such as focus mode and profiles rebind it.
+var shortcuts map[rune]string
 
 type ShortcutAction struct {
-	Name   string
-	Action func(*http.Client) error
+	Name       string
+	Action     func(*SpotifyClient) error
+	NeedsState bool // Reads player state first, so it can be prefetched in low-latency mode
+}
+
+// Action registry keyed by canonical name - populated at init
+var actions = map[string]ShortcutAction{}
+
+// Serializes actions triggered from the keyboard loop and the media-key listener;
+// released while an action waits on the user (see withoutActionLock)
+var actionMu sync.Mutex
+
+// Set while the running action came from the key loop, the only caller whose
+// prompts can be answered: media keys and the HTTP API run while the loop is
+// blocked reading a key. Guarded by actionMu.
+var promptAllowed bool
+
+var errNoPrompt = errors.New("can't prompt outside the terminal key loop")
+
+// RegisterAction adds an action to the registry under a canonical name,
+// replacing any action previously registered with that name. Call it from init:
+// the listeners read the registry without a lock once main starts.
+func RegisterAction(name string, a ShortcutAction) {
+	actions[name] = a
 }
 
 var oauthConfig *oauth2.Config
 
+// Volume step used by volumeUp/volumeDown, adjustable at runtime
+var volumeStep = 10
+
+// Distance moved by seekForward/seekBackward, fine by default and coarse
+// after toggleSeekGranularity
+var (
+	seekStepMs       = 10000
+	coarseSeekStepMs = 30000
+	seekCoarse       bool
+)
+
+// Upper bound for any volume this controller sets
+var maxVolume = 100
+
+// Show the resulting volume after adjustVolume, or the change applied when false
+var volumeDisplayAbsolute = true
+
+// Window for the second press when EZSPOTIFY_QUIT_REQUIRES_HOLD is set
+const quitConfirmWindow = time.Second
+
+const (
+	minVolumeStep = 1
+	maxVolumeStep = 100
+)
+
 //go:embed cert.pem
 var certPEM []byte
 
@@ -51,17 +116,32 @@ func init() {
 	// Load .env file if it exists (won't error if file doesn't exist)
 	godotenv.Load()
 
+	if config, err := loadConfig(); err != nil {
+		log.Printf("Failed to load config file: %v\n", err)
+	} else {
+		configValues = config.values()
+	}
+
 	// Load configuration from environment
-	clientID = getEnv("EZSPOTIFY_CLIENT_ID", "")
-	clientSecret = getEnv("EZSPOTIFY_CLIENT_SECRET", "")
+	clientID = getEnvOrFile("EZSPOTIFY_CLIENT_ID")
+	clientSecret = getEnvOrFile("EZSPOTIFY_CLIENT_SECRET")
 	localPort = getEnv("EZSPOTIFY_LOCAL_PORT", "9120")
 	certFile = getEnv("EZSPOTIFY_CERT_FILE", "")
 	keyFile = getEnv("EZSPOTIFY_KEY_FILE", "")
 	redirectURL = "https://127.0.0.1:" + localPort + "/callback"
-
-	if clientID == "" || clientSecret == "" {
-		log.Fatal("EZSPOTIFY_CLIENT_ID and EZSPOTIFY_CLIENT_SECRET must be set")
+	autoClose = getEnvBool("EZSPOTIFY_AUTOCLOSE_CALLBACK", false)
+	authCodeFile = getEnv("EZSPOTIFY_AUTH_CODE_FILE", "")
+	if timeout, err := time.ParseDuration(getEnv("EZSPOTIFY_AUTH_TIMEOUT", "5m")); err == nil && timeout > 0 {
+		authTimeout = timeout
+	} else {
+		log.Printf("Invalid EZSPOTIFY_AUTH_TIMEOUT, using %s\n", authTimeout)
 	}
+	tokenFile = getEnv("EZSPOTIFY_TOKEN_FILE", defaultTokenFile())
+
+	apiBaseURL = strings.TrimSuffix(getEnv("EZSPOTIFY_API_BASE_URL", apiBaseURL), "/")
+	endpointOverrides = parseEndpointOverrides(getEnv("EZSPOTIFY_ENDPOINT_OVERRIDES", ""))
+	userAgent = getEnv("EZSPOTIFY_USER_AGENT", userAgent)
+	debug = getEnvBool("EZSPOTIFY_DEBUG", false)
 
 	// Initialize OAuth config
 	oauthConfig = &oauth2.Config{
@@ -71,28 +151,311 @@ func init() {
 		Scopes: []string{
 			"user-modify-playback-state",
 			"user-read-playback-state",
+			"user-library-read",
+			"user-library-modify",
+			"playlist-modify-private",
+			"playlist-modify-public",
 		},
 		Endpoint: spotify.Endpoint,
 	}
+	if clientSecret == "" {
+		// Public client: PKCE stands in for the secret and the client ID goes in the body
+		oauthConfig.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+
+	// Register built-in actions
+	RegisterAction("play-pause", ShortcutAction{Name: "Play/Pause", Action: togglePlayback, NeedsState: true})
+	RegisterAction("force-play", ShortcutAction{Name: "Play", Action: forcePlay})
+	RegisterAction("force-pause", ShortcutAction{Name: "Pause", Action: forcePause})
+	RegisterAction("next", ShortcutAction{Name: "Next Track", Action: nextTrack})
+	RegisterAction("prev", ShortcutAction{Name: "Previous Track", Action: previousTrack})
+	RegisterAction("seek-forward", ShortcutAction{Name: "Seek Forward", Action: seekForward, NeedsState: true})
+	RegisterAction("seek-backward", ShortcutAction{Name: "Seek Backward", Action: seekBackward, NeedsState: true})
+	RegisterAction("seek-granularity", ShortcutAction{Name: "Toggle Fine/Coarse Seek", Action: toggleSeekGranularity})
+	RegisterAction("volume-up", ShortcutAction{Name: "Volume Up", Action: volumeUp, NeedsState: true})
+	RegisterAction("volume-down", ShortcutAction{Name: "Volume Down", Action: volumeDown, NeedsState: true})
+	RegisterAction("target-volume-up", ShortcutAction{Name: "Target Device Volume Up", Action: targetVolumeUp})
+	RegisterAction("target-volume-down", ShortcutAction{Name: "Target Device Volume Down", Action: targetVolumeDown})
+	RegisterAction("call-duck", ShortcutAction{Name: "Call Mode (duck volume)", Action: toggleCallDuck, NeedsState: true})
+	RegisterAction("quality-info", ShortcutAction{Name: "Show Audio Quality Info", Action: showQualityInfo})
+	RegisterAction("loudness-info", ShortcutAction{Name: "Show Loudness Info", Action: showLoudnessInfo, NeedsState: true})
+	RegisterAction("mute", ShortcutAction{Name: "Mute/Unmute", Action: mute, NeedsState: true})
+	RegisterAction("volume-fade", ShortcutAction{Name: "Toggle Step/Fade Volume", Action: toggleVolumeFade})
+	RegisterAction("volume-display", ShortcutAction{Name: "Toggle Volume Display", Action: toggleVolumeDisplay})
+	RegisterAction("step-up", ShortcutAction{Name: "Increase Volume Step", Action: increaseVolumeStep})
+	RegisterAction("step-down", ShortcutAction{Name: "Decrease Volume Step", Action: decreaseVolumeStep})
+	RegisterAction("play-album", ShortcutAction{Name: "Play Album", Action: playAlbum, NeedsState: true})
+	RegisterAction("artist-top", ShortcutAction{Name: "Play Artist Top Tracks", Action: playArtistTopTracks})
+	RegisterAction("now-playing-after", ShortcutAction{Name: "Toggle Now Playing After Actions", Action: toggleNowPlayingAfterAction})
+	RegisterAction("shuffle", ShortcutAction{Name: "Toggle Shuffle", Action: toggleShuffle, NeedsState: true})
+	RegisterAction("context-info", ShortcutAction{Name: "Show Playing Context", Action: showContext, NeedsState: true})
+	RegisterAction("toggle-playlist", ShortcutAction{Name: "Switch Playlist", Action: togglePlaylist, NeedsState: true})
+	RegisterAction("smart-shuffle", ShortcutAction{Name: "Smart Shuffle (approximation)", Action: smartShuffle})
+	RegisterAction("help", ShortcutAction{Name: "Help", Action: showHelp})
+	RegisterAction("refresh", ShortcutAction{Name: "Refresh Screen", Action: printBanner})
+	RegisterAction("dump-state", ShortcutAction{Name: "Dump Player State", Action: dumpPlayerState})
+	RegisterAction("progress", ShortcutAction{Name: "Show Progress", Action: printProgress, NeedsState: true})
+	RegisterAction("now-playing", ShortcutAction{Name: "Now Playing", Action: printNowPlaying})
+	RegisterAction("like", ShortcutAction{Name: "Save to Library", Action: saveCurrentTrack})
+	RegisterAction("add-playlist", ShortcutAction{Name: "Add to Playlist", Action: addToPlaylist})
+	RegisterAction("toggle-like", ShortcutAction{Name: "Toggle Like", Action: toggleLike})
+	RegisterAction("export-liked", ShortcutAction{Name: "Export Session Likes", Action: exportLikedTracks})
+	RegisterAction("copy-timestamp", ShortcutAction{Name: "Copy Link at Current Time", Action: copyTimestampLink, NeedsState: true})
+	RegisterAction("album-art", ShortcutAction{Name: "Show Cover Art URLs", Action: printAlbumArt})
+	RegisterAction("open-album-art", ShortcutAction{Name: "Open Cover Art", Action: openAlbumArt})
+	RegisterAction("copy-id", ShortcutAction{Name: "Copy Track ID", Action: copyTrackID})
+	RegisterAction("repeat", ShortcutAction{Name: "Cycle Repeat Mode", Action: cycleRepeat, NeedsState: true})
+	RegisterAction("repeat-times", ShortcutAction{Name: "Repeat Track N Times", Action: repeatTrackTimes, NeedsState: true})
+	RegisterAction("focus", ShortcutAction{Name: "Toggle Focus Mode", Action: toggleFocusMode})
+	RegisterAction("privacy", ShortcutAction{Name: "Toggle Privacy Mode", Action: togglePrivacyMode})
+	RegisterAction("listen-time", ShortcutAction{Name: "Show Listening Time", Action: printListeningTime})
+	RegisterAction("toggle-poller", ShortcutAction{Name: "Toggle Background Polling", Action: togglePoller})
+	RegisterAction("devices", ShortcutAction{Name: "Select Device", Action: pickDevice})
+	RegisterAction("queue-album", ShortcutAction{Name: "Queue Album", Action: queueAlbum})
+	RegisterAction("cycle-device", ShortcutAction{Name: "Next Device", Action: cycleDevice})
+	RegisterAction("queue-search", ShortcutAction{Name: "Search and Queue", Action: promptQueueSearch})
+	RegisterAction("export-queue", ShortcutAction{Name: "Save Queue Snapshot", Action: exportQueue})
+	RegisterAction("show-queue", ShortcutAction{Name: "Show Queue", Action: showQueue})
+	RegisterAction("reauth", ShortcutAction{Name: "Re-authenticate", Action: reauthenticate})
+	RegisterAction("cycle-profile", ShortcutAction{Name: "Next Profile", Action: cycleProfile})
+	registerVolumePresets()
+	registerVolumeDeltas()
+
+	loadProfiles(getEnv("EZSPOTIFY_PROFILES", ""))
+	applyStartupSettings()
+	applySettings()
+
+	// Off by default: every poll spends rate-limit budget
+	interval, err := time.ParseDuration(getEnv("EZSPOTIFY_POLL_INTERVAL", "0"))
+	if err != nil {
+		log.Printf("Invalid EZSPOTIFY_POLL_INTERVAL, polling disabled: %v\n", err)
+	}
+	pollInterval = interval
+	if ttsAnnounce && pollInterval <= 0 {
+		log.Printf("EZSPOTIFY_TTS_ANNOUNCE needs EZSPOTIFY_POLL_INTERVAL to detect track changes\n")
+	}
+
+	sessionSummary = getEnvBool("EZSPOTIFY_SESSION_SUMMARY", false)
+	startupBeep = getEnvBool("EZSPOTIFY_STARTUP_BEEP", false)
+
+	if value := getEnv("EZSPOTIFY_IDLE_EXIT", ""); value != "" {
+		idleExit, err = time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid EZSPOTIFY_IDLE_EXIT, idle exit disabled: %v\n", err)
+		}
+	}
+	idleMediaKeys = getEnvBool("EZSPOTIFY_IDLE_EXIT_MEDIA_KEYS", false)
+
+	if ms, err := strconv.Atoi(getEnv("EZSPOTIFY_DEDUP_WINDOW_MS", "150")); err == nil && ms >= 0 {
+		dedupWindow = time.Duration(ms) * time.Millisecond
+	} else {
+		log.Printf("Invalid EZSPOTIFY_DEDUP_WINDOW_MS, using %s\n", dedupWindow)
+	}
 
+	cliAliases = parseAliases(getEnv("EZSPOTIFY_ALIASES", ""))
+
+	if gap, err := time.ParseDuration(getEnv("EZSPOTIFY_RESUME_GAP", "30s")); err == nil {
+		resumeGap = gap
+	} else {
+		log.Printf("Invalid EZSPOTIFY_RESUME_GAP, resume detection disabled: %v\n", err)
+	}
+
+	httpAPIPort = getEnv("EZSPOTIFY_HTTP_API_PORT", "")
+	httpAPIBind = getEnv("EZSPOTIFY_HTTP_API_BIND", "127.0.0.1")
+	httpAPIToken = getEnvOrFile("EZSPOTIFY_HTTP_API_TOKEN")
+}
+
+// applyStartupSettings loads the settings read outside actionMu, by the key
+// loop, the media-key listener, the poller or the exit path. A profile switch
+// runs under actionMu, so these keep their startup values.
+func applyStartupSettings() {
+	lowLatency = getEnvBool("EZSPOTIFY_LOW_LATENCY", false)
+	quitRequiresHold = getEnvBool("EZSPOTIFY_QUIT_REQUIRES_HOLD", false)
+	pauseOnExit = getEnvBool("EZSPOTIFY_PAUSE_ON_EXIT", false)
+	ttsAnnounce = getEnvBool("EZSPOTIFY_TTS_ANNOUNCE", false)
+
+	if times, err := strconv.Atoi(getEnv("EZSPOTIFY_REPEAT_TIMES", "3")); err == nil {
+		repeatTimes = times
+	} else {
+		log.Printf("Invalid EZSPOTIFY_REPEAT_TIMES, using %d: %v\n", repeatTimes, err)
+	}
+}
+
+// applySettings loads the bindings and settings a profile switch can change
+// at runtime, all read only by actions under actionMu. Credentials, the poll
+// interval, idle exit and applyStartupSettings are startup-only.
+func applySettings() {
 	// Load keyboard shortcuts from environment
-	shortcuts = map[rune]ShortcutAction{
-		rune(getEnv("EZSPOTIFY_KEY_PLAY_PAUSE", " ")[0]):  {Name: "Play/Pause", Action: togglePlayback},
-		rune(getEnv("EZSPOTIFY_KEY_NEXT", "n")[0]):        {Name: "Next Track", Action: nextTrack},
-		rune(getEnv("EZSPOTIFY_KEY_PREV", "p")[0]):        {Name: "Previous Track", Action: previousTrack},
-		rune(getEnv("EZSPOTIFY_KEY_VOLUME_UP", "+")[0]):   {Name: "Volume Up", Action: volumeUp},
-		rune(getEnv("EZSPOTIFY_KEY_VOLUME_DOWN", "-")[0]): {Name: "Volume Down", Action: volumeDown},
-		rune(getEnv("EZSPOTIFY_KEY_MUTE", "m")[0]):        {Name: "Mute", Action: mute},
+	shortcuts = map[rune]string{
+		rune(getEnv("EZSPOTIFY_KEY_PLAY_PAUSE", " ")[0]):         "play-pause",
+		rune(getEnv("EZSPOTIFY_KEY_FORCE_PLAY", "o")[0]):         "force-play",
+		rune(getEnv("EZSPOTIFY_KEY_FORCE_PAUSE", "x")[0]):        "force-pause",
+		rune(getEnv("EZSPOTIFY_KEY_NEXT", "n")[0]):               "next",
+		rune(getEnv("EZSPOTIFY_KEY_PREV", "p")[0]):               "prev",
+		rune(getEnv("EZSPOTIFY_KEY_SEEK_FORWARD", ".")[0]):       "seek-forward",
+		rune(getEnv("EZSPOTIFY_KEY_SEEK_BACKWARD", ",")[0]):      "seek-backward",
+		rune(getEnv("EZSPOTIFY_KEY_SEEK_GRANULARITY", "g")[0]):   "seek-granularity",
+		rune(getEnv("EZSPOTIFY_KEY_VOLUME_UP", "+")[0]):          "volume-up",
+		rune(getEnv("EZSPOTIFY_KEY_VOLUME_DOWN", "-")[0]):        "volume-down",
+		rune(getEnv("EZSPOTIFY_KEY_TARGET_VOLUME_UP", "}")[0]):   "target-volume-up",
+		rune(getEnv("EZSPOTIFY_KEY_TARGET_VOLUME_DOWN", "{")[0]): "target-volume-down",
+		rune(getEnv("EZSPOTIFY_KEY_CALL_DUCK", "j")[0]):          "call-duck",
+		rune(getEnv("EZSPOTIFY_KEY_QUALITY_INFO", "B")[0]):       "quality-info",
+		rune(getEnv("EZSPOTIFY_KEY_LOUDNESS_INFO", "I")[0]):      "loudness-info",
+		rune(getEnv("EZSPOTIFY_KEY_MUTE", "m")[0]):               "mute",
+		rune(getEnv("EZSPOTIFY_KEY_VOLUME_FADE", "F")[0]):        "volume-fade",
+		rune(getEnv("EZSPOTIFY_KEY_VOLUME_DISPLAY", "V")[0]):     "volume-display",
+		rune(getEnv("EZSPOTIFY_KEY_STEP_UP", "]")[0]):            "step-up",
+		rune(getEnv("EZSPOTIFY_KEY_STEP_DOWN", "[")[0]):          "step-down",
+		rune(getEnv("EZSPOTIFY_KEY_PLAY_ALBUM", "A")[0]):         "play-album",
+		rune(getEnv("EZSPOTIFY_KEY_ARTIST_TOP", "T")[0]):         "artist-top",
+		rune(getEnv("EZSPOTIFY_KEY_SHUFFLE", "s")[0]):            "shuffle",
+		rune(getEnv("EZSPOTIFY_KEY_CONTEXT_INFO", "O")[0]):       "context-info",
+		rune(getEnv("EZSPOTIFY_KEY_TOGGLE_PLAYLIST", "b")[0]):    "toggle-playlist",
+		rune(getEnv("EZSPOTIFY_KEY_SMART_SHUFFLE", "S")[0]):      "smart-shuffle",
+		rune(getEnv("EZSPOTIFY_KEY_HELP", "?")[0]):               "help",
+		rune(getEnv("EZSPOTIFY_KEY_REFRESH", "~")[0]):            "refresh",
+		rune(getEnv("EZSPOTIFY_KEY_NOW_PLAYING_AFTER", "N")[0]):  "now-playing-after",
+		rune(getEnv("EZSPOTIFY_KEY_DUMP_STATE", "D")[0]):         "dump-state",
+		rune(getEnv("EZSPOTIFY_KEY_PROGRESS", "t")[0]):           "progress",
+		rune(getEnv("EZSPOTIFY_KEY_NOW_PLAYING", "i")[0]):        "now-playing",
+		rune(getEnv("EZSPOTIFY_KEY_LIKE", "l")[0]):               "like",
+		rune(getEnv("EZSPOTIFY_KEY_ADD_PLAYLIST", "a")[0]):       "add-playlist",
+		rune(getEnv("EZSPOTIFY_KEY_TOGGLE_LIKE", "h")[0]):        "toggle-like",
+		rune(getEnv("EZSPOTIFY_KEY_EXPORT_LIKED", "e")[0]):       "export-liked",
+		rune(getEnv("EZSPOTIFY_KEY_COPY_TIMESTAMP", "C")[0]):     "copy-timestamp",
+		rune(getEnv("EZSPOTIFY_KEY_ALBUM_ART", "k")[0]):          "album-art",
+		rune(getEnv("EZSPOTIFY_KEY_OPEN_ALBUM_ART", "K")[0]):     "open-album-art",
+		rune(getEnv("EZSPOTIFY_KEY_COPY_ID", "c")[0]):            "copy-id",
+		rune(getEnv("EZSPOTIFY_KEY_REPEAT", "r")[0]):             "repeat",
+		rune(getEnv("EZSPOTIFY_KEY_REPEAT_TIMES", "L")[0]):       "repeat-times",
+		rune(getEnv("EZSPOTIFY_KEY_FOCUS", "f")[0]):              "focus",
+		rune(getEnv("EZSPOTIFY_KEY_PRIVACY", "v")[0]):            "privacy",
+		rune(getEnv("EZSPOTIFY_KEY_LISTEN_TIME", "y")[0]):        "listen-time",
+		rune(getEnv("EZSPOTIFY_KEY_TOGGLE_POLLER", "z")[0]):      "toggle-poller",
+		rune(getEnv("EZSPOTIFY_KEY_DEVICES", "d")[0]):            "devices",
+		rune(getEnv("EZSPOTIFY_KEY_CYCLE_DEVICE", "w")[0]):       "cycle-device",
+		rune(getEnv("EZSPOTIFY_KEY_QUEUE_ALBUM", "Q")[0]):        "queue-album",
+		rune(getEnv("EZSPOTIFY_KEY_QUEUE_SEARCH", "/")[0]):       "queue-search",
+		rune(getEnv("EZSPOTIFY_KEY_EXPORT_QUEUE", "U")[0]):       "export-queue",
+		rune(getEnv("EZSPOTIFY_KEY_SHOW_QUEUE", "u")[0]):         "show-queue",
+		rune(getEnv("EZSPOTIFY_KEY_REAUTH", "R")[0]):             "reauth",
+		rune(getEnv("EZSPOTIFY_KEY_CYCLE_PROFILE", "P")[0]):      "cycle-profile",
+	}
+
+	keyRunes = defaultKeyRunes()
+	parseKeyMap(getEnv("EZSPOTIFY_KEY_MAP", ""))
+
+	bindVolumePresets(getEnv("EZSPOTIFY_VOLUME_PRESETS", defaultVolumePresets))
+
+	likedExportFile = getEnv("EZSPOTIFY_LIKED_EXPORT_FILE", "")
+	queueExportFile = getEnv("EZSPOTIFY_QUEUE_EXPORT_FILE", "queue_snapshot.json")
+
+	deviceVolumes = parseDeviceVolumes(getEnv("EZSPOTIFY_DEVICE_VOLUMES", ""))
+	defaultDevice = getEnv("EZSPOTIFY_DEFAULT_DEVICE", "")
+	targetDevice = getEnv("EZSPOTIFY_TARGET_DEVICE", "")
+	copyContextURL = getEnvBool("EZSPOTIFY_COPY_CONTEXT_URL", false)
+	targetPlaylistID = getEnv("EZSPOTIFY_TARGET_PLAYLIST_ID", "")
+	playlistToggleA = getEnv("EZSPOTIFY_PLAYLIST_TOGGLE_A", "")
+	playlistToggleB = getEnv("EZSPOTIFY_PLAYLIST_TOGGLE_B", "")
+	wakeDevice = getEnvBool("EZSPOTIFY_WAKE_DEVICE", false)
+
+	if retries, err := strconv.Atoi(getEnv("EZSPOTIFY_DEVICE_RETRIES", "3")); err == nil && retries > 0 {
+		deviceRetries = retries
+	} else {
+		log.Printf("Invalid EZSPOTIFY_DEVICE_RETRIES, using %d\n", deviceRetries)
+	}
+	if delay, err := time.ParseDuration(getEnv("EZSPOTIFY_DEVICE_RETRY_DELAY", "1s")); err == nil && delay >= 0 {
+		deviceRetryDelay = delay
+	} else {
+		log.Printf("Invalid EZSPOTIFY_DEVICE_RETRY_DELAY, using %s\n", deviceRetryDelay)
 	}
+	if step, err := strconv.Atoi(getEnv("EZSPOTIFY_VOLUME_STEP", "10")); err == nil && step >= minVolumeStep && step <= maxVolumeStep {
+		volumeStep = step
+	} else {
+		log.Printf("Invalid EZSPOTIFY_VOLUME_STEP, must be %d-%d; using 10\n", minVolumeStep, maxVolumeStep)
+		volumeStep = 10
+	}
+	if step, err := strconv.Atoi(getEnv("EZSPOTIFY_SEEK_STEP_MS", "10000")); err == nil && step > 0 {
+		seekStepMs = step
+	} else {
+		log.Printf("Invalid EZSPOTIFY_SEEK_STEP_MS, using %dms\n", seekStepMs)
+	}
+	if step, err := strconv.Atoi(getEnv("EZSPOTIFY_SEEK_COARSE_STEP_MS", "30000")); err == nil && step > 0 {
+		coarseSeekStepMs = step
+	} else {
+		log.Printf("Invalid EZSPOTIFY_SEEK_COARSE_STEP_MS, using %dms\n", coarseSeekStepMs)
+	}
+
+	volumeFade = getEnvBool("EZSPOTIFY_VOLUME_FADE", false)
+	if duration, err := time.ParseDuration(getEnv("EZSPOTIFY_FADE_DURATION", "500ms")); err == nil && duration > 0 {
+		fadeDuration = duration
+	} else {
+		log.Printf("Invalid EZSPOTIFY_FADE_DURATION, using %s\n", fadeDuration)
+	}
+
+	nowPlayingAfterAction = getEnvBool("EZSPOTIFY_SHOW_NOWPLAYING_AFTER_ACTION", false)
+
+	switch display := getEnv("EZSPOTIFY_VOLUME_DISPLAY", "absolute"); display {
+	case "absolute", "delta":
+		volumeDisplayAbsolute = display == "absolute"
+	default:
+		log.Printf("Invalid EZSPOTIFY_VOLUME_DISPLAY %q, using absolute\n", display)
+	}
+
+	if limit, err := strconv.Atoi(getEnv("EZSPOTIFY_MAX_VOLUME_CAP", "100")); err == nil && limit >= 0 && limit <= 100 {
+		maxVolume = limit
+	} else {
+		log.Println("Invalid EZSPOTIFY_MAX_VOLUME_CAP, must be 0-100; using 100")
+	}
+
+
+	if volume, err := strconv.Atoi(getEnv("EZSPOTIFY_CALL_VOLUME", "10")); err == nil && volume >= 0 && volume <= 100 {
+		callVolume = volume
+	} else {
+		log.Println("Invalid EZSPOTIFY_CALL_VOLUME, must be 0-100; using 10")
+		callVolume = 10
+	}
+	callPause = getEnvBool("EZSPOTIFY_CALL_PAUSE", false)
+	soundFeedback = getEnvBool("EZSPOTIFY_SOUND_FEEDBACK", false)
+	notifications = getEnvBool("EZSPOTIFY_NOTIFICATIONS", false)
 }
 
 func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
+// getEnvOrFile returns the value of key, or else the trimmed contents of the
+// file named by key_FILE (Docker/Kubernetes secret style), or else the config
+// file's value.
+func getEnvOrFile(key string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return configValues[key]
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read %s_FILE: %v\n", key, err)
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(lookupEnv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
 func openBrowser(url string) error {
 	var cmd string
 	var args []string
@@ -112,39 +475,142 @@ func openBrowser(url string) error {
 	return exec.Command(cmd, args...).Start()
 }
 
+func copyToClipboard(text string) error {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("clip")
+	case "darwin":
+		cmd = exec.Command("pbcopy")
+	default: // Linux, BSD, etc.
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			cmd = exec.Command("wl-copy")
+		} else {
+			cmd = exec.Command("xclip", "-selection", "clipboard")
+		}
+	}
+
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// Exit codes for fatal errors, so service managers can tell them apart
+const (
+	exitError    = 1 // anything not covered below
+	exitConfig   = 2 // missing credentials or invalid flags
+	exitAuth     = 3 // authorization failed, timed out or was denied
+	exitCert     = 4 // TLS certificate for the callback server unusable
+	exitTerminal = 5 // keyboard input unavailable
+)
+
+// fatal logs v like log.Fatal, then exits with code.
+func fatal(code int, v ...any) {
+	log.Print(v...)
+	os.Exit(code)
+}
+
 func main() {
+	dumpState := flag.Bool("dump-state", false, "print the raw player state JSON and exit")
+	doLogout := flag.Bool("logout", false, "revoke and delete the saved token, then exit")
+	flag.Parse()
+
+	if *doLogout {
+		if err := logout(); err != nil {
+			fatal(exitError, "Logout failed:", err)
+		}
+		return
+	}
+
+	if clientID == "" {
+		fatal(exitConfig, "EZSPOTIFY_CLIENT_ID must be set")
+	}
+
+	// One-shot commands (ez_spotify next) run alongside an interactive instance
+	oneShot := flag.NArg() > 0
+	if !oneShot && !acquireInstanceLock() {
+		log.Printf("Another instance is already using %s; token refreshes are serialized but shortcuts may fire twice\n", tokenFile)
+	}
+
 	token, err := loadToken()
+	if err == nil && token.RefreshToken == "" {
+		// Without a refresh token everything stops working once the access token expires
+		if getEnvBool("EZSPOTIFY_REQUIRE_REFRESH_TOKEN", true) {
+			err = errors.New("token has no refresh token")
+		} else {
+			log.Printf("Warning: token has no refresh token and will stop working at %s\n", token.Expiry.Format(time.Kitchen))
+		}
+	}
 	if err != nil {
-		log.Println("No valid token found, starting OAuth flow...")
+		log.Printf("No valid token found (%v), starting OAuth flow...\n", err)
 		token, err = authenticate()
 		if err != nil {
-			log.Fatal("Authentication failed:", err)
+			fatal(exitAuth, "Authentication failed:", err)
 		}
 	}
 
-	client := createAutoRefreshClient(token)
+	client := newSpotifyClient(createAutoRefreshClient(token))
 
-	fmt.Println("\n🎵 Spotify Controller Ready!")
-	fmt.Println("Available shortcuts:")
-	for key, shortcut := range shortcuts {
-		if key == ' ' {
-			fmt.Printf("  [Space] - %s\n", shortcut.Name)
-		} else {
-			fmt.Printf("  [%c] - %s\n", key, shortcut.Name)
+	if *dumpState {
+		if err := dumpPlayerState(client); err != nil {
+			fatal(exitError, "Failed to dump player state:", err)
+		}
+		return
+	}
+
+	if oneShot {
+		if err := runCommand(client, flag.Args()); err != nil {
+			fatal(exitError, err)
 		}
+		return
+	}
+
+	fmt.Println("\n🎵 Spotify Controller Ready!")
+	if startupBeep {
+		beep()
 	}
-	fmt.Println("  [q] - Quit")
-	fmt.Println("  Media keys (Play/Pause, Next, Previous) are also supported")
-	fmt.Println()
+	printShortcuts()
 
-	// Start media key listener in background
-	go listenMediaKeys(client)
+	if err := printNowPlaying(client); err != nil {
+		log.Printf("Error fetching now playing: %v\n", err)
+	}
+
+	// Before any listener goroutine, which may reset it
+	startIdleTimer(client)
+
+	// Start media key listener and player state poller in background
+	ctx, cancelMediaKeys := context.WithCancel(context.Background())
+	mediaKeysDone := make(chan struct{})
+	go func() {
+		defer close(mediaKeysDone)
+		listenMediaKeys(ctx, client)
+	}()
+	stopMediaKeys = func() {
+		cancelMediaKeys()
+		select {
+		case <-mediaKeysDone:
+		case <-time.After(mediaKeysStopTimeout):
+			log.Println("Media key listener did not stop in time")
+		}
+	}
+	startPoller(client)
+	startHTTPAPI(client)
+	startResumeWatcher()
 
 	if err := keyboard.Open(); err != nil {
-		log.Fatal("Failed to initialize keyboard:", err)
+		fatal(exitTerminal, "Failed to initialize keyboard:", err)
 	}
 	defer keyboard.Close()
 
+	// Ctrl-C may arrive as a signal instead of a key, depending on the terminal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		shutdown(client, "Exiting...")
+	}()
+
+	var quitPressed time.Time
 	for {
 		char, key, err := keyboard.GetKey()
 		if err != nil {
@@ -152,20 +618,111 @@ func main() {
 			continue
 		}
 
-		if key == keyboard.KeyEsc || char == 'q' {
+		resetIdleTimer()
+
+		if key == keyboard.KeyEsc || key == keyboard.KeyCtrlC || char == 'q' {
+			// Ctrl-C is deliberate enough to always quit at once
+			if quitRequiresHold && key != keyboard.KeyCtrlC && time.Since(quitPressed) > quitConfirmWindow {
+				quitPressed = time.Now()
+				fmt.Printf("Press again within %s to quit\n", quitConfirmWindow)
+				continue
+			}
 			fmt.Println("\nExiting...")
+			beforeExit(client)
 			break
 		}
 
-		if shortcut, exists := shortcuts[char]; exists {
-			fmt.Printf("Executing: %s\n", shortcut.Name)
-			if err := shortcut.Action(client); err != nil {
-				log.Printf("Error executing %s: %v\n", shortcut.Name, err)
+		quitPressed = time.Time{}
+
+		actionMu.Lock()
+		name, exists := shortcuts[normalizeKey(char, key)]
+		actionMu.Unlock()
+		if exists {
+			if shortcut, exists := actions[name]; exists {
+				if lowLatency && shortcut.NeedsState {
+					prefetchPlayerState(client)
+				}
+				if !firstDispatch(name, "keyboard") {
+					continue
+				}
+				fmt.Printf("Executing: %s\n", shortcut.Name)
+				runAction(client, name, true)
 			}
 		}
 	}
 }
 
+// beforeExit runs the steps shared by every exit path, before the keyboard is released.
+func beforeExit(client *SpotifyClient) {
+	stopMediaKeys()
+	if pauseOnExit {
+		// Don't let an unreachable or rate-limited API hold up exiting
+		ctx, cancel := context.WithTimeout(context.Background(), pauseOnExitTimeout)
+		if err := client.callOnce(ctx, "PUT", "/me/player/pause"); err != nil {
+			log.Printf("Failed to pause playback on exit: %v\n", err)
+		}
+		cancel()
+	}
+	printSessionSummary()
+}
+
+// Longest beforeExit waits for the pause on exit
+const pauseOnExitTimeout = 3 * time.Second
+
+// Stops the media-key listener and waits for it to release the hook; set in main
+var stopMediaKeys = func() {}
+
+// Longest beforeExit waits for the media-key listener to stop
+const mediaKeysStopTimeout = 2 * time.Second
+
+// shutdown restores the terminal and exits from outside the main key loop.
+func shutdown(client *SpotifyClient, reason string) {
+	fmt.Println("\n" + reason)
+	beforeExit(client)
+	keyboard.Close()
+	os.Exit(0)
+}
+
+// runAction executes the registered action with the given name, logging and
+// returning any error. Only the key loop passes interactive, allowing prompts.
+func runAction(client *SpotifyClient, name string, interactive bool) error {
+	shortcut, exists := actions[name]
+	if !exists {
+		log.Printf("Unknown action: %s\n", name)
+		return fmt.Errorf("unknown action: %s", name)
+	}
+
+	actionMu.Lock()
+	defer actionMu.Unlock()
+	promptAllowed = interactive
+	defer func() { promptAllowed = false }()
+	defer discardPrefetch()
+
+	wakeActiveDevice(client)
+	err := shortcut.Action(client)
+	if isNoActiveDevice(err) {
+		// Typically after the laptop sleeps: pick a device and try once more
+		if err = ensureActiveDevice(client); err == nil {
+			err = shortcut.Action(client)
+		}
+		if errors.Is(err, errChoiceCancelled) {
+			err = errNoActiveDevice
+		}
+	}
+	recordAction(name, err)
+	feedback(err == nil)
+	if err != nil {
+		log.Printf("Error executing %s: %v\n", shortcut.Name, err)
+	}
+
+	if nowPlayingAfterAction && name != "now-playing" {
+		if err := printNowPlaying(client); err != nil {
+			log.Printf("Error fetching now playing: %v\n", err)
+		}
+	}
+	return err
+}
+
 // createHttpsServer creates an HTTPS server with the provided or embedded TLS certificates.
 func createHttpsServer() *http.Server {
 	// Read TLS certificates from file if configured in environment
@@ -187,7 +744,7 @@ func createHttpsServer() *http.Server {
 
 	cert, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
-		log.Fatalf("Failed to load TLS certificates: %v", err)
+		fatal(exitCert, "Failed to load TLS certificates: ", err)
 	}
 
 	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
@@ -199,84 +756,314 @@ func createHttpsServer() *http.Server {
 	return server
 }
 
-func listenMediaKeys(client *http.Client) {
+var errChoiceCancelled = errors.New("cancelled")
+
+// withoutActionLock runs fn with actionMu released, for the parts of an action
+// that wait on the user (a prompt, the browser), so media keys and the HTTP API
+// keep working meanwhile. The caller must be an action holding actionMu.
+func withoutActionLock(fn func()) {
+	allowed := promptAllowed
+	actionMu.Unlock()
+	defer func() {
+		actionMu.Lock()
+		promptAllowed = allowed
+	}()
+	fn()
+}
+
+// readChoice waits for a digit key between 1 and max (at most 9), Esc or Ctrl-C cancels.
+func readChoice(max int) (int, error) {
+	if !promptAllowed {
+		return 0, errNoPrompt
+	}
+	if max > 9 {
+		max = 9
+	}
+	fmt.Printf("Press 1-%d to choose, Esc to cancel\n", max)
+
+	var choice int
+	var err error
+	withoutActionLock(func() {
+		for {
+			var char rune
+			var key keyboard.Key
+			if char, key, err = keyboard.GetKey(); err != nil {
+				return
+			}
+			if key == keyboard.KeyEsc || key == keyboard.KeyCtrlC {
+				err = errChoiceCancelled
+				return
+			}
+			if char >= '1' && char <= '9' && int(char-'0') <= max {
+				choice = int(char - '0')
+				return
+			}
+		}
+	})
+	return choice, err
+}
+
+// readLine reads a line of text at the prompt, echoing it as it's typed. Enter
+// finishes, Backspace deletes and Esc or Ctrl-C cancels.
+func readLine(prompt string) (string, error) {
+	if !promptAllowed {
+		return "", errNoPrompt
+	}
+	fmt.Print(prompt)
+
+	var line []rune
+	var err error
+	withoutActionLock(func() {
+		for {
+			var char rune
+			var key keyboard.Key
+			if char, key, err = keyboard.GetKey(); err != nil {
+				return
+			}
+			switch {
+			case key == keyboard.KeyEsc || key == keyboard.KeyCtrlC:
+				fmt.Println()
+				err = errChoiceCancelled
+				return
+			case key == keyboard.KeyEnter:
+				fmt.Println()
+				return
+			case key == keyboard.KeyBackspace || key == keyboard.KeyBackspace2:
+				if len(line) > 0 {
+					line = line[:len(line)-1]
+					fmt.Print("\b \b")
+				}
+			case key == keyboard.KeySpace:
+				line = append(line, ' ')
+				fmt.Print(" ")
+			case char != 0:
+				line = append(line, char)
+				fmt.Print(string(char))
+			}
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
+
+// Window in which the same action arriving from the media-key hook and from the
+// keyboard counts as one press, from EZSPOTIFY_DEDUP_WINDOW_MS; 0 disables
+var dedupWindow = 150 * time.Millisecond
+
+var lastDispatch struct {
+	sync.Mutex
+	name   string
+	source string
+	at     time.Time
+}
+
+// firstDispatch reports whether an action from source should run, returning
+// false when the other source triggered the same action within dedupWindow.
+// Repeats from one source always run.
+func firstDispatch(name, source string) bool {
+	lastDispatch.Lock()
+	defer lastDispatch.Unlock()
+
+	now := time.Now()
+	if lastDispatch.name == name && lastDispatch.source != source && now.Sub(lastDispatch.at) < dedupWindow {
+		debugf("Ignoring %s from %s, already triggered by %s", name, source, lastDispatch.source)
+		return false
+	}
+	lastDispatch.name, lastDispatch.source, lastDispatch.at = name, source, now
+	return true
+}
+
+// Media key raw codes mapped to action names
+var mediaKeys = mediaKeysFor(runtime.GOOS)
+
+// Raw media key codes reported by the hook on each OS; others (BSDs) use the
+// Windows/Linux codes
+var mediaKeysByOS = map[string]map[uint16]string{
+	"windows": windowsMediaKeys,
+	"linux":   windowsMediaKeys,
+	// gohook reports macOS media keys as kVK_MEDIA_* (0xE0 | NX_KEYTYPE_*)
+	"darwin": {
+		240: "play-pause", // kVK_MEDIA_Play (0xE0 | 0x10)
+		242: "next",       // kVK_MEDIA_Next (0xE0 | 0x12)
+		243: "prev",       // kVK_MEDIA_Previous (0xE0 | 0x13)
+	},
+}
+
+var windowsMediaKeys = map[uint16]string{
+	179: "play-pause", // VK_MEDIA_PLAY_PAUSE
+	176: "next",       // VK_MEDIA_NEXT_TRACK
+	177: "prev",       // VK_MEDIA_PREV_TRACK
+}
+
+func mediaKeysFor(goos string) map[uint16]string {
+	if keys, ok := mediaKeysByOS[goos]; ok {
+		return keys
+	}
+	return windowsMediaKeys
+}
+
+// mediaKeyHint describes what the global key hook needs on the current OS.
+func mediaKeyHint() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "grant your terminal Accessibility and Input Monitoring permission in System Settings > Privacy & Security"
+	case "windows":
+		return "run the controller from an interactive desktop session"
+	default:
+		return "an X11 session (or XWayland) with DISPLAY set is required"
+	}
+}
+
+// Time without any hook event, keyboard or mouse, after which a debug note
+// with the setup hint is logged. The user may simply be idle, so it's no proof
+// the hook lacks permission.
+const mediaKeyProbeTimeout = 30 * time.Second
+
+// listenMediaKeys dispatches global media keys until ctx is cancelled, then
+// removes the hook. The hook reports no error when it can't attach, so if it
+// sees no input at all for a while, the OS-specific setup hint is logged in
+// debug mode.
+func listenMediaKeys(ctx context.Context, client *SpotifyClient) {
+	if runtime.GOOS == "linux" && os.Getenv("DISPLAY") == "" {
+		log.Printf("Media keys disabled: %s\n", mediaKeyHint())
+		return
+	}
+
 	evChan := hook.Start()
 	defer hook.End()
 
-	for ev := range evChan {
-		if ev.Kind != hook.KeyDown {
+	probe := time.After(mediaKeyProbeTimeout)
+	for {
+		var ev hook.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case <-probe:
+			debugf("No global input seen yet in %s; if media keys don't respond, %s", mediaKeyProbeTimeout, mediaKeyHint())
+			probe = nil
 			continue
+		case ev, ok = <-evChan:
 		}
-
-		var action func(*http.Client) error
-		var actionName string
-
-		switch ev.Rawcode {
-		case 179: // Play/Pause (Windows/Linux)
-			action = togglePlayback
-			actionName = "Play/Pause"
-		case 176: // Next track (Windows/Linux)
-			action = nextTrack
-			actionName = "Next Track"
-		case 177: // Previous track (Windows/Linux)
-			action = previousTrack
-			actionName = "Previous Track"
+		if !ok {
+			return
+		}
+		// Any event proves the hook is attached
+		probe = nil
+		if ev.Kind != hook.KeyDown {
+			continue
 		}
 
-		if action != nil {
-			fmt.Printf("Media key: %s\n", actionName)
-			if err := action(client); err != nil {
-				log.Printf("Error executing %s: %v\n", actionName, err)
+		if name, exists := mediaKeys[ev.Rawcode]; exists {
+			if focusMode.Load() && focusBlocked[name] {
+				continue
+			}
+			if idleMediaKeys {
+				resetIdleTimer()
+			}
+			if lowLatency && actions[name].NeedsState {
+				prefetchPlayerState(client)
 			}
+			if !firstDispatch(name, "media") {
+				continue
+			}
+			fmt.Printf("Media key: %s\n", actions[name].Name)
+			runAction(client, name, false)
 		}
 	}
 }
 
 func authenticate() (*oauth2.Token, error) {
-	state := "random-state-string"
-	authURL := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
-
-	codeChan := make(chan string)
-	errChan := make(chan error)
+	state, err := randomState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate state: %w", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+	authURL := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+
+	codeChan := make(chan string, 1)
+	errChan := make(chan error, 1)
+
+	// A local mux so authenticate can run again in the same process
+	mux := http.NewServeMux()
+
+	// Browsers may hit the callback more than once (preloads, refreshes), so
+	// only the first valid result is delivered and later hits get a polite reply
+	var once sync.Once
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "Invalid authorization request, please retry from the controller.", http.StatusBadRequest)
+			return
+		}
 
-	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Query().Get("state") != state {
-			errChan <- fmt.Errorf("state mismatch")
+		if authErr := query.Get("error"); authErr != "" {
+			fmt.Fprintf(w, "Authorization failed: %s", authErr)
+			once.Do(func() { errChan <- fmt.Errorf("authorization denied: %s", authErr) })
 			return
 		}
 
-		code := r.URL.Query().Get("code")
+		code := query.Get("code")
 		if code == "" {
-			errChan <- fmt.Errorf("no code in response")
+			http.Error(w, "No authorization code in request.", http.StatusBadRequest)
 			return
 		}
 
+		delivered := false
+		once.Do(func() {
+			codeChan <- code
+			delivered = true
+		})
+		if !delivered {
+			fmt.Fprintf(w, "Already authorized. You can close this window.")
+			return
+		}
+		if autoClose {
+			// Best-effort: browsers only allow scripts to close tabs they opened
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprintf(w, "<p>Authorization successful! You can close this window.</p><script>window.close()</script>")
+			return
+		}
 		fmt.Fprintf(w, "Authorization successful! You can close this window.")
-		codeChan <- code
 	})
 
 	server := createHttpsServer()
+	server.Handler = mux
+
+	ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+	defer cancel()
 
 	go server.ListenAndServeTLS("", "")
-	defer server.Shutdown(context.Background())
+	defer server.Shutdown(ctx)
 
 	fmt.Println("Opening browser for authorization...")
 	if err := openBrowser(authURL); err != nil {
-		log.Printf("Failed to open browser: %v", err)
+		log.Printf("Failed to open browser: %v\n", err)
 	}
 	fmt.Println("If browser doesn't open, visit this URL:")
 	fmt.Println(authURL)
 
+	if authCodeFile != "" {
+		done := make(chan struct{})
+		defer close(done)
+		go watchAuthCodeFile(authCodeFile, state, done, func(code string) {
+			once.Do(func() { codeChan <- code })
+		})
+		fmt.Printf("Or write the redirect URL or code to %s\n", authCodeFile)
+	}
+
 	var code string
 	select {
 	case code = <-codeChan:
 	case err := <-errChan:
 		return nil, err
-	case <-time.After(5 * time.Minute):
-		return nil, fmt.Errorf("authorization timeout")
+	case <-ctx.Done():
+		return nil, fmt.Errorf("authorization not completed within %s (set EZSPOTIFY_AUTH_TIMEOUT to allow longer)", authTimeout)
 	}
 
-	token, err := oauthConfig.Exchange(context.Background(), code)
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		return nil, err
 	}
@@ -285,6 +1072,68 @@ func authenticate() (*oauth2.Token, error) {
 	return token, nil
 }
 
+// randomState returns an unguessable OAuth state value for one authorization attempt.
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// How often watchAuthCodeFile checks EZSPOTIFY_AUTH_CODE_FILE
+const authCodePollInterval = time.Second
+
+// watchAuthCodeFile waits for path to be written after the flow starts and
+// delivers the code it holds, either bare or as the full redirect URL. The
+// file is removed once read since the code is single-use.
+func watchAuthCodeFile(path, state string, done <-chan struct{}, deliver func(code string)) {
+	var startMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		startMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(authCodePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(startMod) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		content := strings.TrimSpace(string(data))
+		if content == "" {
+			continue
+		}
+		os.Remove(path)
+
+		code := content
+		if redirect, err := url.Parse(content); err == nil && redirect.Query().Has("code") {
+			if s := redirect.Query().Get("state"); s != "" && s != state {
+				log.Printf("Ignoring %s: state doesn't match this authorization request\n", path)
+				startMod = info.ModTime()
+				continue
+			}
+			code = redirect.Query().Get("code")
+		}
+		deliver(code)
+		return
+	}
+}
+
+// Token source behind every client from createAutoRefreshClient; replaced on
+// re-authentication so clients already handed out pick up the new token
+var clientTokens = &swappableTokenSource{}
+
 func createAutoRefreshClient(token *oauth2.Token) *http.Client {
 	tokenSource := oauthConfig.TokenSource(context.Background(), token)
 
@@ -293,32 +1142,287 @@ func createAutoRefreshClient(token *oauth2.Token) *http.Client {
 		src: tokenSource,
 	}
 
-	return oauth2.NewClient(context.Background(), wrappedSource)
+	clientTokens.set(oauth2.ReuseTokenSource(token, wrappedSource))
+	return &http.Client{Transport: &oauth2.Transport{Source: clientTokens}}
 }
 
-type autoSaveTokenSource struct {
+type swappableTokenSource struct {
+	mu  sync.Mutex
 	src oauth2.TokenSource
 }
 
+func (s *swappableTokenSource) set(src oauth2.TokenSource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src = src
+}
+
+func (s *swappableTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	src := s.src
+	s.mu.Unlock()
+	if src == nil {
+		return nil, errors.New("not authenticated")
+	}
+	return src.Token()
+}
+
+// forceTokenRefresh exchanges the refresh token for a new access token even if
+// the current one hasn't expired, saving it and handing it to every client.
+func forceTokenRefresh() error {
+	current, err := clientTokens.Token()
+	if err != nil {
+		return err
+	}
+	if current.RefreshToken == "" {
+		return errors.New("token has no refresh token")
+	}
+
+	// A token with only a refresh token counts as expired, so the source refreshes at once
+	src := &autoSaveTokenSource{
+		src: oauthConfig.TokenSource(context.Background(), &oauth2.Token{RefreshToken: current.RefreshToken}),
+	}
+	token, err := src.Token()
+	if err != nil {
+		return err
+	}
+	clientTokens.set(oauth2.ReuseTokenSource(token, src))
+	return nil
+}
+
+// reauthenticate runs the OAuth flow again mid-session, then moves playback
+// back to the device that was active before.
+func reauthenticate(client *SpotifyClient) error {
+	var device Device
+	if state, err := getPlayerState(client); err == nil {
+		device = state.Device
+	}
+
+	// The browser flow can take up to authTimeout; only the token swap needs the lock
+	var token *oauth2.Token
+	var err error
+	withoutActionLock(func() { token, err = authenticate() })
+	if err != nil {
+		return err
+	}
+	createAutoRefreshClient(token)
+	clearSavedTracks()
+	fmt.Println("Re-authenticated")
+
+	if device.ID == "" {
+		return nil
+	}
+	if err := transferPlayback(client, device); err != nil {
+		return fmt.Errorf("failed to restore playback on %s: %w", device.Name, err)
+	}
+	fmt.Printf("Restored playback on %s\n", device.Name)
+	return nil
+}
+
+type autoSaveTokenSource struct {
+	src  oauth2.TokenSource
+	save func(*oauth2.Token) error // Persists refreshed tokens, defaults to saveToken
+}
+
 func (a *autoSaveTokenSource) Token() (*oauth2.Token, error) {
 	token, err := a.src.Token()
 	if err != nil {
 		return nil, err
 	}
-	saveToken(token)
+
+	save := a.save
+	if save == nil {
+		save = saveToken
+	}
+	if err := save(token); err != nil {
+		log.Printf("Failed to save token: %v\n", err)
+	}
 	return token, nil
 }
 
+// Held for the lifetime of the process to detect a second instance sharing the token file
+var instanceLock *os.File
+
+// acquireInstanceLock reports whether no other instance holds the token file's instance lock.
+func acquireInstanceLock() bool {
+	// On first run the config directory doesn't exist until the token is saved
+	if err := os.MkdirAll(filepath.Dir(tokenFile), 0700); err != nil {
+		return true
+	}
+	f, err := os.OpenFile(tokenFile+".instance", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return true
+	}
+	if err := lockFile(f, false); err != nil {
+		f.Close()
+		return false
+	}
+	instanceLock = f
+	return true
+}
+
+// withTokenLock runs fn while holding an exclusive lock shared by all instances using the token file.
+func withTokenLock(fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(tokenFile), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tokenFile+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := lockFile(f, true); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	return fn()
+}
+
+// Token location before it moved to the config directory, still read if present
+const legacyTokenFile = "spotify_token.json"
+
+// defaultTokenFile returns $XDG_CONFIG_HOME/ez_spotify/spotify_token.json,
+// falling back to ~/.config and then to the working directory.
+func defaultTokenFile() string {
+	dir, err := configDir()
+	if err != nil {
+		return legacyTokenFile
+	}
+	return filepath.Join(dir, "spotify_token.json")
+}
+
 func saveToken(token *oauth2.Token) error {
 	data, err := json.Marshal(token)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(tokenFile, data, 0600)
+	if err := os.MkdirAll(filepath.Dir(tokenFile), 0700); err != nil {
+		return err
+	}
+	return withTokenLock(func() error {
+		// Write then rename so a concurrent reader never sees a partial file
+		tmpFile := tokenFile + ".tmp"
+		if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+			return err
+		}
+		return os.Rename(tmpFile, tokenFile)
+	})
+}
+
+// checkTokenPermissions warns about (or with EZSPOTIFY_STRICT_TOKEN_PERMS refuses)
+// a token file readable by other users, optionally fixing it to 0600.
+func checkTokenPermissions() error {
+	if runtime.GOOS == "windows" {
+		return nil // Unix permission bits don't apply
+	}
+
+	info, err := os.Stat(tokenFile)
+	if err != nil {
+		return nil // Reported by the read itself
+	}
+	perm := info.Mode().Perm()
+	if perm&0077 == 0 {
+		return nil
+	}
+
+	if getEnvBool("EZSPOTIFY_FIX_TOKEN_PERMS", false) {
+		if err := os.Chmod(tokenFile, 0600); err != nil {
+			return fmt.Errorf("failed to restrict %s permissions: %w", tokenFile, err)
+		}
+		log.Printf("Restricted %s permissions from %04o to 0600\n", tokenFile, perm)
+		return nil
+	}
+	if getEnvBool("EZSPOTIFY_STRICT_TOKEN_PERMS", false) {
+		return fmt.Errorf("%s has permissions %04o but contains a refresh token; chmod it to 0600", tokenFile, perm)
+	}
+	log.Printf("Warning: %s has permissions %04o but contains a refresh token; chmod it to 0600\n", tokenFile, perm)
+	return nil
+}
+
+// Spotify has no token revocation endpoint; EZSPOTIFY_REVOKE_URL can point at an
+// RFC 7009 compatible one (e.g. behind a gateway) to invalidate the refresh token.
+const spotifyAppsURL = "https://www.spotify.com/account/apps/"
+
+// logout attempts to revoke the saved token server-side, then deletes it locally.
+func logout() error {
+	token, err := loadToken()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Not logged in")
+			return nil
+		}
+		log.Printf("Failed to read token, deleting it anyway: %v\n", err)
+	}
+
+	if revokeURL := getEnv("EZSPOTIFY_REVOKE_URL", ""); revokeURL != "" && token != nil {
+		if err := revokeToken(revokeURL, token.RefreshToken); err != nil {
+			log.Printf("Token revocation failed: %v\n", err)
+		} else {
+			fmt.Println("Revoked refresh token")
+		}
+	} else {
+		fmt.Printf("Spotify doesn't support token revocation; remove access at %s\n", spotifyAppsURL)
+	}
+
+	if err := withTokenLock(func() error { return os.Remove(tokenFile) }); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	fmt.Printf("Deleted %s\n", tokenFile)
+
+	if tokenFile != legacyTokenFile {
+		if err := os.Remove(legacyTokenFile); err == nil {
+			fmt.Printf("Deleted %s\n", legacyTokenFile)
+		}
+	}
+	return nil
+}
+
+// revokeToken sends an RFC 7009 revocation request for a refresh token.
+func revokeToken(revokeURL, refreshToken string) error {
+	form := url.Values{
+		"token":           {refreshToken},
+		"token_type_hint": {"refresh_token"},
+	}
+	if clientSecret == "" {
+		form.Set("client_id", clientID)
+	}
+	req, err := http.NewRequest("POST", revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientSecret != "" {
+		req.SetBasicAuth(clientID, clientSecret)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
 }
 
 func loadToken() (*oauth2.Token, error) {
-	data, err := os.ReadFile(tokenFile)
+	if err := checkTokenPermissions(); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	err := withTokenLock(func() error {
+		var err error
+		data, err = os.ReadFile(tokenFile)
+		return err
+	})
+	legacy := false
+	if errors.Is(err, os.ErrNotExist) && tokenFile != legacyTokenFile {
+		// Saved before the token moved to the config directory
+		data, err = os.ReadFile(legacyTokenFile)
+		legacy = err == nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -326,109 +1430,486 @@ func loadToken() (*oauth2.Token, error) {
 	if err := json.Unmarshal(data, &token); err != nil {
 		return nil, err
 	}
+	if legacy {
+		if err := saveToken(&token); err != nil {
+			log.Printf("Failed to copy %s to %s: %v\n", legacyTokenFile, tokenFile, err)
+		} else {
+			log.Printf("Copied %s to %s\n", legacyTokenFile, tokenFile)
+		}
+	}
 	return &token, nil
 }
 
 // Spotify API Actions
-func togglePlayback(client *http.Client) error {
-	resp, err := client.Get("https://api.spotify.com/v1/me/player")
+func togglePlayback(client *SpotifyClient) error {
+	state, err := getPlayerState(client)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 204 {
-		return fmt.Errorf("no active device")
+	endpoint := "/me/player/pause"
+	if !state.IsPlaying {
+		endpoint = "/me/player/play"
 	}
 
-	var state struct {
-		IsPlaying bool `json:"is_playing"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+	if err := client.put(endpoint, nil); err != nil {
 		return err
 	}
+	if state.Item != nil {
+		title := "Paused"
+		if !state.IsPlaying {
+			title = "Playing"
+		}
+		notify(title, trackSummary(state.Item))
+	}
+	return nil
+}
 
-	endpoint := "https://api.spotify.com/v1/me/player/pause"
-	if !state.IsPlaying {
-		endpoint = "https://api.spotify.com/v1/me/player/play"
+// forcePlay resumes playback without checking the current state.
+func forcePlay(client *SpotifyClient) error {
+	return client.put("/me/player/play", nil)
+}
+
+// forcePause pauses playback without checking the current state.
+func forcePause(client *SpotifyClient) error {
+	return client.put("/me/player/pause", nil)
+}
+
+// Time for Spotify to report the new track after a skip
+var trackChangeDelay = 300 * time.Millisecond
+
+func nextTrack(client *SpotifyClient) error {
+	if err := skipToNext(client); err != nil {
+		return err
 	}
+	return printTrackAfterSkip(client)
+}
 
-	req, _ := http.NewRequest("PUT", endpoint, nil)
-	resp, err = client.Do(req)
-	if err != nil {
+func previousTrack(client *SpotifyClient) error {
+	if err := client.post("/me/player/previous", nil); err != nil {
 		return err
 	}
-	resp.Body.Close()
+	return printTrackAfterSkip(client)
+}
+
+// skipToNext skips to the next track without printing it.
+func skipToNext(client *SpotifyClient) error {
+	return client.post("/me/player/next", nil)
+}
+
+// printTrackAfterSkip waits for the skip to take effect, then shows the new
+// track in a notification if enabled and prints it unless runAction is about to.
+func printTrackAfterSkip(client *SpotifyClient) error {
+	time.Sleep(trackChangeDelay)
+	notifyCurrentTrack(client, "Now playing")
+	if nowPlayingAfterAction {
+		return nil
+	}
+	return printNowPlaying(client)
+}
+
+func seekForward(client *SpotifyClient) error {
+	return seekBy(client, currentSeekStep())
+}
+
+func seekBackward(client *SpotifyClient) error {
+	return seekBy(client, -currentSeekStep())
+}
+
+func currentSeekStep() int {
+	if seekCoarse {
+		return coarseSeekStepMs
+	}
+	return seekStepMs
+}
+
+func toggleSeekGranularity(_ *SpotifyClient) error {
+	seekCoarse = !seekCoarse
+	if seekCoarse {
+		fmt.Printf("Seek step: coarse (%s)\n", time.Duration(coarseSeekStepMs)*time.Millisecond)
+	} else {
+		fmt.Printf("Seek step: fine (%s)\n", time.Duration(seekStepMs)*time.Millisecond)
+	}
 	return nil
 }
 
-func nextTrack(client *http.Client) error {
-	req, _ := http.NewRequest("POST", "https://api.spotify.com/v1/me/player/next", nil)
-	resp, err := client.Do(req)
+// seekBy moves playback by deltaMs within the current item, clamped to [0, duration].
+func seekBy(client *SpotifyClient, deltaMs int) error {
+	state, err := getPlayerState(client)
 	if err != nil {
 		return err
 	}
-	resp.Body.Close()
+	if state.Item == nil || state.Item.DurationMs == 0 {
+		return fmt.Errorf("nothing playing to seek in")
+	}
+
+	position := state.ProgressMs + deltaMs
+	if position < 0 {
+		position = 0
+	}
+	if position > state.Item.DurationMs {
+		position = state.Item.DurationMs
+	}
+
+	if err := client.put(fmt.Sprintf("/me/player/seek?position_ms=%d", position), nil); err != nil {
+		return err
+	}
+
+	if position == state.Item.DurationMs {
+		fmt.Println("Seek: reached the end of the track")
+	} else {
+		fmt.Printf("Seek: %s / %s\n", formatTrackTime(position), formatTrackTime(state.Item.DurationMs))
+	}
 	return nil
 }
 
-func previousTrack(client *http.Client) error {
-	req, _ := http.NewRequest("POST", "https://api.spotify.com/v1/me/player/previous", nil)
-	resp, err := client.Do(req)
+// formatTrackTime renders milliseconds as m:ss, rounding down to the second.
+func formatTrackTime(ms int) string {
+	seconds := ms / 1000
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func volumeUp(client *SpotifyClient) error {
+	if volumeFade {
+		return fadeVolume(client, volumeStep)
+	}
+	return adjustVolume(client, "", volumeStep)
+}
+
+func volumeDown(client *SpotifyClient) error {
+	if volumeFade {
+		return fadeVolume(client, -volumeStep)
+	}
+	return adjustVolume(client, "", -volumeStep)
+}
+
+// Fade mode: volume keys ramp over fadeDuration instead of jumping
+var (
+	volumeFade   bool
+	fadeDuration = 500 * time.Millisecond
+)
+
+// Interval between the volume requests of a fade
+const fadeInterval = 100 * time.Millisecond
+
+// fadeVolume ramps the active device's volume by delta over fadeDuration.
+func fadeVolume(client *SpotifyClient, delta int) error {
+	state, err := activeDeviceState(client)
 	if err != nil {
 		return err
 	}
-	resp.Body.Close()
+	start := state.Device.VolumePercent
+	target := volumeAfter(start, delta)
+
+	steps := max(int(fadeDuration/fadeInterval), 1)
+	for i := 1; i <= steps && target != start; i++ {
+		volume := start + (target-start)*i/steps
+		if err := client.put(fmt.Sprintf("/me/player/volume?volume_percent=%d", volume), nil); err != nil {
+			return err
+		}
+		if i < steps {
+			time.Sleep(fadeInterval)
+		}
+	}
+
+	if volumeDisplayAbsolute {
+		fmt.Printf("Volume: %d%%\n", target)
+	} else {
+		fmt.Printf("Volume: %+d%%\n", target-start)
+	}
 	return nil
 }
 
-func volumeUp(client *http.Client) error {
-	return adjustVolume(client, 10)
+func toggleVolumeFade(_ *SpotifyClient) error {
+	volumeFade = !volumeFade
+	if volumeFade {
+		fmt.Printf("Volume mode: fade (%s)\n", fadeDuration)
+	} else {
+		fmt.Println("Volume mode: step")
+	}
+	return nil
 }
 
-func volumeDown(client *http.Client) error {
-	return adjustVolume(client, -10)
+func increaseVolumeStep(_ *SpotifyClient) error {
+	return setVolumeStep(volumeStep + 1)
 }
 
-func mute(client *http.Client) error {
-	req, _ := http.NewRequest("PUT", "https://api.spotify.com/v1/me/player/volume?volume_percent=0", nil)
-	resp, err := client.Do(req)
+func decreaseVolumeStep(_ *SpotifyClient) error {
+	return setVolumeStep(volumeStep - 1)
+}
+
+// setVolumeStep clamps step to [minVolumeStep, maxVolumeStep] and prints the result.
+func setVolumeStep(step int) error {
+	if step < minVolumeStep {
+		step = minVolumeStep
+	}
+	if step > maxVolumeStep {
+		step = maxVolumeStep
+	}
+	volumeStep = step
+	fmt.Printf("Volume step: %d%%\n", volumeStep)
+	return nil
+}
+
+// Volume saved by mute and restored when it's pressed again
+var lastVolume int
+
+// Restored by mute when no earlier volume was saved
+const defaultUnmuteVolume = 50
+
+// mute toggles between silence and the volume the device had before muting.
+func mute(client *SpotifyClient) error {
+	state, err := activeDeviceState(client)
 	if err != nil {
 		return err
 	}
-	resp.Body.Close()
+
+	if state.Device.VolumePercent > 0 {
+		lastVolume = state.Device.VolumePercent
+		if err := client.put("/me/player/volume?volume_percent=0", nil); err != nil {
+			return err
+		}
+		fmt.Println("Muted")
+		return nil
+	}
+
+	volume := lastVolume
+	if volume <= 0 {
+		volume = defaultUnmuteVolume
+	}
+	volume = clampVolume(volume)
+	if err := client.put(fmt.Sprintf("/me/player/volume?volume_percent=%d", volume), nil); err != nil {
+		return err
+	}
+	fmt.Printf("Unmuted: %d%%\n", volume)
 	return nil
 }
 
-func adjustVolume(client *http.Client, delta int) error {
-	resp, err := client.Get("https://api.spotify.com/v1/me/player")
+// Volume and pause behaviour for toggleCallDuck, from EZSPOTIFY_CALL_VOLUME and EZSPOTIFY_CALL_PAUSE
+var (
+	callVolume = 10
+	callPause  bool
+)
+
+// State saved by toggleCallDuck so the second press restores it exactly
+var callDuck struct {
+	active     bool
+	deviceID   string
+	volume     int
+	wasPlaying bool
+}
+
+// toggleCallDuck drops to the call volume (optionally pausing) and, on the
+// next press, restores the previous volume and resumes.
+func toggleCallDuck(client *SpotifyClient) error {
+	if callDuck.active {
+		endpoint := fmt.Sprintf("/me/player/volume?volume_percent=%d&device_id=%s", callDuck.volume, url.QueryEscape(callDuck.deviceID))
+		if err := client.put(endpoint, nil); err != nil {
+			return err
+		}
+		callDuck.active = false
+		if callPause && callDuck.wasPlaying {
+			if err := forcePlay(client); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Call over: volume %d%%\n", callDuck.volume)
+		return nil
+	}
+
+	state, err := activeDeviceState(client)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	volume := clampVolume(callVolume)
+	if err := client.put(fmt.Sprintf("/me/player/volume?volume_percent=%d", volume), nil); err != nil {
+		return err
+	}
+	callDuck.active = true
+	callDuck.deviceID = state.Device.ID
+	callDuck.volume = state.Device.VolumePercent
+	callDuck.wasPlaying = state.IsPlaying
+
+	if callPause && state.IsPlaying {
+		if err := forcePause(client); err != nil {
+			return err
+		}
+		fmt.Printf("Call mode: paused, volume %d%%\n", volume)
+		return nil
+	}
+	fmt.Printf("Call mode: volume %d%%\n", volume)
+	return nil
+}
 
-	var state struct {
-		Device struct {
-			VolumePercent int `json:"volume_percent"`
-		} `json:"device"`
+// clampVolume limits volume to [0, maxVolume].
+func clampVolume(volume int) int {
+	if volume < 0 {
+		return 0
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
-		return err
+	if volume > maxVolume {
+		return maxVolume
+	}
+	return volume
+}
+
+// volumeAfter returns current changed by delta within [0, maxVolume], except
+// that raising a volume already above the cap (set from another app) leaves it
+// alone rather than pulling it down to the cap.
+func volumeAfter(current, delta int) int {
+	if delta > 0 && current > maxVolume {
+		return current
+	}
+	return clampVolume(current + delta)
+}
+
+// adjustVolume changes the volume of the device with deviceID by delta, or of
+// the active device when deviceID is empty.
+func adjustVolume(client *SpotifyClient, deviceID string, delta int) error {
+	var device Device
+	if deviceID == "" {
+		state, err := activeDeviceState(client)
+		if err != nil {
+			return err
+		}
+		device = state.Device
+	} else {
+		devices, err := listDevices(client)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, d := range devices {
+			if d.ID == deviceID {
+				device, found = d, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("device %s is not available", deviceID)
+		}
 	}
 
-	newVolume := state.Device.VolumePercent + delta
-	if newVolume < 0 {
-		newVolume = 0
+	newVolume := volumeAfter(device.VolumePercent, delta)
+	if newVolume != device.VolumePercent {
+		if err := putVolume(client, deviceID, newVolume); err != nil {
+			return err
+		}
 	}
-	if newVolume > 100 {
-		newVolume = 100
+
+	label := "Volume"
+	if deviceID != "" {
+		label = device.Name + " volume"
 	}
+	if volumeDisplayAbsolute {
+		fmt.Printf("%s: %d%%\n", label, newVolume)
+	} else {
+		fmt.Printf("%s: %+d%%\n", label, newVolume-device.VolumePercent)
+	}
+	return nil
+}
 
-	req, _ := http.NewRequest("PUT", fmt.Sprintf("https://api.spotify.com/v1/me/player/volume?volume_percent=%d", newVolume), nil)
-	resp, err = client.Do(req)
-	if err != nil {
+// setVolume jumps the active device straight to percent, clamped like any other volume.
+func setVolume(client *SpotifyClient, percent int) error {
+	percent = clampVolume(percent)
+	if err := putVolume(client, "", percent); err != nil {
 		return err
 	}
-	resp.Body.Close()
+	fmt.Printf("Volume: %d%%\n", percent)
+	return nil
+}
+
+// putVolume sets the volume of deviceID, or of the active device when empty.
+func putVolume(client *SpotifyClient, deviceID string, percent int) error {
+	endpoint := fmt.Sprintf("/me/player/volume?volume_percent=%d", percent)
+	if deviceID != "" {
+		endpoint += "&device_id=" + url.QueryEscape(deviceID)
+	}
+	return client.put(endpoint, nil)
+}
+
+// Preset levels bound by default: 1-9 for 10-90%, 0 for 100%
+const defaultVolumePresets = "1=10,2=20,3=30,4=40,5=50,6=60,7=70,8=80,9=90,0=100"
+
+// bindVolumePresets parses "key=percent" pairs (EZSPOTIFY_VOLUME_PRESETS) and
+// binds each key to its volume-N action. Keys already bound, the quit key and
+// characters EZSPOTIFY_KEY_MAP produces keep their meaning.
+func bindVolumePresets(value string) {
+	for _, entry := range strings.Split(value, ",") {
+		key, level, found := strings.Cut(strings.TrimSpace(entry), "=")
+		percent, err := strconv.Atoi(strings.TrimSpace(level))
+		if !found || len(key) != 1 || err != nil || percent < 0 || percent > 100 {
+			if entry != "" {
+				log.Printf("Ignoring invalid volume preset %q\n", entry)
+			}
+			continue
+		}
+
+		char := rune(key[0])
+		if existing, taken := shortcuts[char]; taken {
+			log.Printf("Volume preset key %q is already bound to %s, skipping %d%% preset\n", char, existing, percent)
+			continue
+		}
+		if char == 'q' {
+			log.Printf("Volume preset key 'q' quits, skipping %d%% preset\n", percent)
+			continue
+		}
+		if name, mapped := mappedKeyName(char); mapped {
+			log.Printf("Volume preset key %q is produced by %s in EZSPOTIFY_KEY_MAP, skipping %d%% preset\n", char, name, percent)
+			continue
+		}
+
+		shortcuts[char] = volumePresetName(percent)
+	}
+}
+
+// registerVolumePresets registers a volume-N action for every level from 0 to
+// 100 at startup, so bindVolumePresets only rebinds keys and the action
+// registry never changes while listeners read it.
+func registerVolumePresets() {
+	for percent := 0; percent <= 100; percent++ {
+		RegisterAction(volumePresetName(percent), ShortcutAction{
+			Name:   fmt.Sprintf("Volume %d%%", percent),
+			Action: func(client *SpotifyClient) error { return setVolume(client, percent) },
+		})
+	}
+}
+
+func volumePresetName(percent int) string {
+	return fmt.Sprintf("volume-%d", percent)
+}
+
+// registerVolumeDeltas registers a volume-by+N/volume-by-N action for every
+// change from -100 to +100, for callers such as the HTTP API that adjust the
+// volume by an arbitrary amount through runAction.
+func registerVolumeDeltas() {
+	for delta := -100; delta <= 100; delta++ {
+		RegisterAction(volumeDeltaName(delta), ShortcutAction{
+			Name:       fmt.Sprintf("Volume %+d%%", delta),
+			Action:     func(client *SpotifyClient) error { return adjustVolume(client, "", delta) },
+			NeedsState: true,
+		})
+	}
+}
+
+// volumeDeltaName returns the volume-by action for delta, limited to ±100.
+func volumeDeltaName(delta int) string {
+	return fmt.Sprintf("volume-by%+d", max(min(delta, 100), -100))
+}
+
+// isVolumeLevelAction reports whether name is one of the generated volume-N
+// or volume-by±N actions.
+func isVolumeLevelAction(name string) bool {
+	level, found := strings.CutPrefix(name, "volume-")
+	_, err := strconv.Atoi(strings.TrimPrefix(level, "by"))
+	return found && err == nil
+}
+
+func toggleVolumeDisplay(_ *SpotifyClient) error {
+	volumeDisplayAbsolute = !volumeDisplayAbsolute
+	if volumeDisplayAbsolute {
+		fmt.Println("Volume display: absolute")
+	} else {
+		fmt.Println("Volume display: delta")
+	}
 	return nil
 }
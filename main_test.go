@@ -0,0 +1,660 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newMockSpotify returns a client for a test server running handler.
+func newMockSpotify(t *testing.T, handler http.HandlerFunc) *SpotifyClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return &SpotifyClient{http: server.Client(), baseURL: server.URL + "/v1"}
+}
+
+func TestTogglePlayback(t *testing.T) {
+	tests := []struct {
+		name      string
+		isPlaying bool
+		wantPath  string
+	}{
+		{name: "pauses when playing", isPlaying: true, wantPath: "/v1/me/player/pause"},
+		{name: "plays when paused", isPlaying: false, wantPath: "/v1/me/player/play"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotMethod, gotPath string
+			client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" && r.URL.Path == "/v1/me/player" {
+					fmt.Fprintf(w, `{"is_playing": %t}`, tt.isPlaying)
+					return
+				}
+				gotMethod, gotPath = r.Method, r.URL.Path
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			if err := togglePlayback(client); err != nil {
+				t.Fatalf("togglePlayback() error = %v", err)
+			}
+			if gotMethod != "PUT" || gotPath != tt.wantPath {
+				t.Errorf("got %s %s, want PUT %s", gotMethod, gotPath, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestTogglePlaybackNoActiveDevice(t *testing.T) {
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := togglePlayback(client); !errors.Is(err, errNoActiveDevice) {
+		t.Errorf("togglePlayback() error = %v, want %v", err, errNoActiveDevice)
+	}
+}
+
+type fakeTokenSource struct {
+	token *oauth2.Token
+	err   error
+}
+
+func (f *fakeTokenSource) Token() (*oauth2.Token, error) {
+	return f.token, f.err
+}
+
+func TestAutoSaveTokenSourceSavesToken(t *testing.T) {
+	want := &oauth2.Token{AccessToken: "new-access", RefreshToken: "refresh"}
+
+	var saved *oauth2.Token
+	source := &autoSaveTokenSource{
+		src: &fakeTokenSource{token: want},
+		save: func(token *oauth2.Token) error {
+			saved = token
+			return nil
+		},
+	}
+
+	got, err := source.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Token() = %v, want %v", got, want)
+	}
+	if saved != want {
+		t.Errorf("saved %v, want %v", saved, want)
+	}
+}
+
+func TestAutoSaveTokenSourcePropagatesError(t *testing.T) {
+	wantErr := errors.New("refresh failed")
+
+	source := &autoSaveTokenSource{
+		src: &fakeTokenSource{err: wantErr},
+		save: func(token *oauth2.Token) error {
+			t.Error("save called after token source error")
+			return nil
+		},
+	}
+
+	if _, err := source.Token(); !errors.Is(err, wantErr) {
+		t.Errorf("Token() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestTimestampLink(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "track",
+			body: `{"progress_ms": 83500, "item": {"uri": "spotify:track:abc", "type": "track"}}`,
+			want: "https://open.spotify.com/track/abc?t=83",
+		},
+		{
+			name: "episode",
+			body: `{"progress_ms": 3600000, "currently_playing_type": "episode", "item": {"uri": "spotify:episode:xyz", "type": "episode"}}`,
+			want: "https://open.spotify.com/episode/xyz?t=3600",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			})
+
+			state, err := fetchPlayerState(client)
+			if err != nil {
+				t.Fatalf("fetchPlayerState() error = %v", err)
+			}
+			if got := timestampLink(state.Item, state.ProgressMs); got != tt.want {
+				t.Errorf("timestampLink() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVolumeDeltaActions(t *testing.T) {
+	if got := volumeDeltaName(250); got != "volume-by+100" {
+		t.Errorf("volumeDeltaName(250) = %q, want volume-by+100", got)
+	}
+
+	var got string
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"device": {"id": "d1", "is_active": true, "volume_percent": 50}}`)
+			return
+		}
+		got = r.URL.Query().Get("volume_percent")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// The HTTP API's /volume?delta=-15
+	if err := runAction(client, volumeDeltaName(-15), false); err != nil {
+		t.Fatalf("runAction() error = %v", err)
+	}
+	if got != "35" {
+		t.Errorf("volume_percent = %s, want 35", got)
+	}
+}
+
+func TestMediaKeysForDarwin(t *testing.T) {
+	// gohook's kVK_MEDIA_* rawcodes (hook/darwin/input.h)
+	want := map[uint16]string{240: "play-pause", 242: "next", 243: "prev"}
+	got := mediaKeysFor("darwin")
+	if len(got) != len(want) {
+		t.Fatalf("mediaKeysFor(darwin) = %v, want %v", got, want)
+	}
+	for code, name := range want {
+		if got[code] != name {
+			t.Errorf("mediaKeysFor(darwin)[%d] = %q, want %q", code, got[code], name)
+		}
+	}
+}
+
+func TestAdjustVolumeClamps(t *testing.T) {
+	original := maxVolume
+	t.Cleanup(func() { maxVolume = original })
+
+	tests := []struct {
+		name    string
+		cap     int
+		current int
+		delta   int
+		want    string // "" when no volume request is expected
+	}{
+		{name: "within range", cap: 100, current: 50, delta: 10, want: "60"},
+		{name: "clamps at 100", cap: 100, current: 95, delta: 10, want: "100"},
+		{name: "clamps at 0", cap: 100, current: 5, delta: -10, want: "0"},
+		{name: "clamps at cap", cap: 80, current: 75, delta: 10, want: "80"},
+		{name: "up above cap leaves volume", cap: 80, current: 90, delta: 10, want: ""},
+		{name: "down above cap lowers to cap", cap: 80, current: 95, delta: -10, want: "80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxVolume = tt.cap
+			var got string
+			client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" && r.URL.Path == "/v1/me/player" {
+					fmt.Fprintf(w, `{"device": {"id": "d1", "is_active": true, "volume_percent": %d}}`, tt.current)
+					return
+				}
+				if r.Method != "PUT" || r.URL.Path != "/v1/me/player/volume" {
+					t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+				}
+				got = r.URL.Query().Get("volume_percent")
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			if err := adjustVolume(client, "", tt.delta); err != nil {
+				t.Fatalf("adjustVolume() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("volume_percent = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipTrack(t *testing.T) {
+	original := trackChangeDelay
+	trackChangeDelay = 20 * time.Millisecond
+	t.Cleanup(func() { trackChangeDelay = original })
+
+	tests := []struct {
+		name        string
+		action      func(*SpotifyClient) error
+		wantPath    string
+		printsAfter bool // nowPlayingAfterAction leaves printing to runAction
+	}{
+		{name: "next", action: nextTrack, wantPath: "/v1/me/player/next"},
+		{name: "previous", action: previousTrack, wantPath: "/v1/me/player/previous"},
+		{name: "next, printed by runAction", action: nextTrack, wantPath: "/v1/me/player/next", printsAfter: true},
+		{name: "previous, printed by runAction", action: previousTrack, wantPath: "/v1/me/player/previous", printsAfter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalAfter := nowPlayingAfterAction
+			nowPlayingAfterAction = tt.printsAfter
+			t.Cleanup(func() { nowPlayingAfterAction = originalAfter })
+
+			var gotMethod, gotPath string
+			var fetched bool
+			client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					fetched = true
+				} else {
+					gotMethod, gotPath = r.Method, r.URL.Path
+				}
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			start := time.Now()
+			if err := tt.action(client); err != nil {
+				t.Fatalf("%s error = %v", tt.name, err)
+			}
+			if gotMethod != "POST" || gotPath != tt.wantPath {
+				t.Errorf("got %s %s, want POST %s", gotMethod, gotPath, tt.wantPath)
+			}
+			// Even when runAction prints, the new track must have had time to load
+			if elapsed := time.Since(start); elapsed < trackChangeDelay {
+				t.Errorf("returned after %s, want at least %s", elapsed, trackChangeDelay)
+			}
+			if fetched == tt.printsAfter {
+				t.Errorf("fetched now playing = %t, want %t", fetched, !tt.printsAfter)
+			}
+		})
+	}
+}
+
+func TestCheckResponseIncludesBodySnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "spotify error",
+			body: `{"error": {"status": 403, "message": "Player command failed: Restriction violated", "reason": "UNKNOWN"}}`,
+			want: "POST /v1/me/player/next: 403 Player command failed: Restriction violated",
+		},
+		{
+			name: "unrecognized body",
+			body: "<html>\n  <body>Forbidden by proxy</body>\n</html>",
+			want: "POST /v1/me/player/next: 403 Forbidden: <html> <body>Forbidden by proxy</body> </html>",
+		},
+		{
+			name: "empty body",
+			want: "POST /v1/me/player/next: 403 Forbidden",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, tt.body)
+			})
+
+			err := skipToNext(client)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("skipToNext() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindVolumePresets(t *testing.T) {
+	originalShortcuts, originalKeyRunes := shortcuts, keyRunes
+	t.Cleanup(func() { shortcuts, keyRunes = originalShortcuts, originalKeyRunes })
+
+	shortcuts = map[rune]string{'n': "next"}
+	keyRunes = defaultKeyRunes()
+	parseKeyMap("f1:7")
+
+	registered := len(actions)
+	bindVolumePresets("5=50,n=20,x=200,q=30,7=70,0=100")
+	if len(actions) != registered {
+		t.Errorf("bindVolumePresets changed the action registry")
+	}
+
+	want := map[rune]string{'n': "next", '5': "volume-50", '0': "volume-100"}
+	if len(shortcuts) != len(want) {
+		t.Fatalf("shortcuts = %v, want %v", shortcuts, want)
+	}
+	for key, name := range want {
+		if shortcuts[key] != name {
+			t.Errorf("shortcuts[%q] = %q, want %q", key, shortcuts[key], name)
+		}
+	}
+
+	var got string
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.Method + " " + r.URL.Path + "?" + r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if err := actions["volume-50"].Action(client); err != nil {
+		t.Fatalf("volume-50 error = %v", err)
+	}
+	if want := "PUT /v1/me/player/volume?volume_percent=50"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestEnsureActiveDeviceWithoutPrompt(t *testing.T) {
+	// Media keys and the HTTP API can't answer the device prompt
+	var transferred string
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v1/me/player/devices":
+			fmt.Fprint(w, `{"devices": [{"id": "d1", "name": "Laptop"}, {"id": "d2", "name": "Phone"}]}`)
+		case r.Method == "PUT" && r.URL.Path == "/v1/me/player":
+			body, _ := io.ReadAll(r.Body)
+			transferred = string(body)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	if err := ensureActiveDevice(client); err != nil {
+		t.Fatalf("ensureActiveDevice() error = %v", err)
+	}
+	if want := `{"device_ids":["d1"]}`; transferred != want {
+		t.Errorf("transfer body = %s, want %s", transferred, want)
+	}
+}
+
+func TestWithoutActionLock(t *testing.T) {
+	actionMu.Lock()
+	promptAllowed = true
+
+	withoutActionLock(func() {
+		// What a media key or HTTP API request does while a prompt is open
+		if !actionMu.TryLock() {
+			t.Fatal("actionMu still held while waiting on the user")
+		}
+		promptAllowed = false
+		actionMu.Unlock()
+	})
+
+	if actionMu.TryLock() {
+		t.Error("actionMu not re-acquired after waiting on the user")
+	}
+	if !promptAllowed {
+		t.Error("promptAllowed not restored after waiting on the user")
+	}
+	promptAllowed = false
+	actionMu.Unlock()
+}
+
+func TestRunActionDiscardsUnusedPrefetch(t *testing.T) {
+	var volume atomic.Int32
+	volume.Store(50)
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"device": {"id": "d1", "is_active": true, "volume_percent": %d}}`, volume.Load())
+	})
+
+	RegisterAction("test-no-state", ShortcutAction{Name: "Test", Action: func(*SpotifyClient) error { return nil }})
+	t.Cleanup(func() { delete(actions, "test-no-state") })
+
+	prefetchPlayerState(client)
+	runAction(client, "test-no-state", false)
+
+	volume.Store(70)
+	state, err := getPlayerState(client)
+	if err != nil {
+		t.Fatalf("getPlayerState() error = %v", err)
+	}
+	if state.Device.VolumePercent != 70 {
+		t.Errorf("volume = %d, want 70 from a fresh fetch", state.Device.VolumePercent)
+	}
+}
+
+func TestPrintNowPlayingRefreshesSavedOnTrackChange(t *testing.T) {
+	t.Cleanup(func() { trackShown("") })
+
+	playing := "a"
+	var lookups int
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/me/player/currently-playing":
+			fmt.Fprintf(w, `{"currently_playing_type": "track", "item": {"id": %q, "name": "Song"}}`, playing)
+		case "/v1/me/tracks/contains":
+			lookups++
+			fmt.Fprint(w, "[true]")
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	// Showing a twice uses the cache; coming back to a after b looks it up again
+	for _, id := range []string{"a", "a", "b", "a"} {
+		playing = id
+		if err := printNowPlaying(client); err != nil {
+			t.Fatalf("printNowPlaying() error = %v", err)
+		}
+	}
+	if lookups != 3 {
+		t.Errorf("library lookups = %d, want 3 (one per track change)", lookups)
+	}
+}
+
+func TestToggleLikeRejectsEpisodes(t *testing.T) {
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/me/player/currently-playing" {
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+			return
+		}
+		fmt.Fprint(w, `{"currently_playing_type": "episode", "item": {"id": "ep1", "name": "Episode", "type": "episode"}}`)
+	})
+
+	if err := toggleLike(client); err == nil {
+		t.Error("toggleLike() error = nil, want an error for an episode")
+	}
+	for _, liked := range sessionLiked {
+		if liked.ID == "ep1" {
+			t.Error("episode recorded as a session like")
+		}
+	}
+}
+
+func TestRejectBrowserRequests(t *testing.T) {
+	handler := rejectBrowserRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}))
+
+	tests := []struct {
+		name   string
+		origin string
+		want   int
+	}{
+		{name: "script", want: http.StatusOK},
+		{name: "web page", origin: "https://example.com", want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/next", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitRetry(t *testing.T) {
+	var calls int
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := skipToNext(client); err != nil {
+		t.Fatalf("skipToNext() error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("requests = %d, want 2", calls)
+	}
+}
+
+func TestRateLimitGivesUp(t *testing.T) {
+	var calls int
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	err := skipToNext(client)
+	if !errors.Is(err, &SpotifyError{StatusCode: http.StatusTooManyRequests}) {
+		t.Errorf("skipToNext() error = %v, want a 429 SpotifyError", err)
+	}
+	if want := maxRateLimitRetries + 1; calls != want {
+		t.Errorf("requests = %d, want %d", calls, want)
+	}
+}
+
+func TestCallOnceSkipsRetryAndRefresh(t *testing.T) {
+	original := refreshOnUnauthorized
+	refreshOnUnauthorized = func() error {
+		t.Error("callOnce refreshed the token")
+		return nil
+	}
+	t.Cleanup(func() { refreshOnUnauthorized = original })
+
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusUnauthorized} {
+		var calls int
+		client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(status)
+		})
+
+		err := client.callOnce(context.Background(), "PUT", "/me/player/pause")
+		if !errors.Is(err, &SpotifyError{StatusCode: status}) {
+			t.Errorf("callOnce() error = %v, want a %d SpotifyError", err, status)
+		}
+		if calls != 1 {
+			t.Errorf("requests on %d = %d, want 1", status, calls)
+		}
+	}
+}
+
+func TestCallOnceHonorsDeadline(t *testing.T) {
+	release := make(chan struct{})
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	t.Cleanup(func() { close(release) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := client.callOnce(ctx, "PUT", "/me/player/pause")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("callOnce() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("callOnce() took %s past its deadline", elapsed)
+	}
+}
+
+func TestUnauthorizedRefreshesAndRetries(t *testing.T) {
+	var refreshed int
+	original := refreshOnUnauthorized
+	refreshOnUnauthorized = func() error {
+		refreshed++
+		return nil
+	}
+	t.Cleanup(func() { refreshOnUnauthorized = original })
+
+	var bodies []string
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	want := `{"device_ids":["d1"]}`
+	if err := client.put("/me/player", strings.NewReader(want)); err != nil {
+		t.Fatalf("put() error = %v", err)
+	}
+	if refreshed != 1 {
+		t.Errorf("refreshes = %d, want 1", refreshed)
+	}
+	if len(bodies) != 2 || bodies[0] != want || bodies[1] != want {
+		t.Errorf("request bodies = %q, want %q twice", bodies, want)
+	}
+}
+
+func TestQueueAlbumPagesThroughClient(t *testing.T) {
+	original := queueRequestDelay
+	queueRequestDelay = 0
+	t.Cleanup(func() { queueRequestDelay = original })
+
+	const total = albumPageSize + 1
+	var queued int
+	client := newMockSpotify(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/me/player/currently-playing":
+			fmt.Fprint(w, `{"item": {"name": "Song", "album": {"id": "al1", "name": "Album"}}}`)
+		case r.URL.Path == "/v1/albums/al1/tracks":
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			var items []string
+			for i := offset; i < min(offset+albumPageSize, total); i++ {
+				items = append(items, fmt.Sprintf(`{"uri": "spotify:track:%d"}`, i))
+			}
+			fmt.Fprintf(w, `{"items": [%s], "total": %d, "next": "https://api.spotify.com/v1/elsewhere"}`, strings.Join(items, ","), total)
+		case r.Method == "POST" && r.URL.Path == "/v1/me/player/queue":
+			queued++
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		}
+	})
+
+	if err := queueAlbum(client); err != nil {
+		t.Fatalf("queueAlbum() error = %v", err)
+	}
+	if queued != total {
+		t.Errorf("queued %d tracks, want %d", queued, total)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errNoActiveDevice = errors.New("no active device")
+
+// Returned by getCurrentTrack during ad breaks, when Spotify reports no item
+var errAdvertisement = errors.New("advertisement playing")
+
+// Player state as returned by GET /v1/me/player
+type playerState struct {
+	IsPlaying    bool             `json:"is_playing"`
+	ProgressMs   int              `json:"progress_ms"`
+	RepeatState  string           `json:"repeat_state"`
+	ShuffleState bool             `json:"shuffle_state"`
+	PlayingType  string           `json:"currently_playing_type"` // "track", "episode", "ad" or "unknown"
+	Device       Device           `json:"device"`
+	Item         *track           `json:"item"`
+	Context      *playbackContext `json:"context"`
+}
+
+// playbackContext is the album, playlist or artist playback was started from.
+type playbackContext struct {
+	Type         string `json:"type"` // "album", "playlist", "artist" or "show"
+	URI          string `json:"uri"`
+	ExternalURLs struct {
+		Spotify string `json:"spotify"`
+	} `json:"external_urls"`
+}
+
+type track struct {
+	ID         string  `json:"id"`
+	URI        string  `json:"uri"`
+	Name       string  `json:"name"`
+	DurationMs int     `json:"duration_ms"`
+	Type       string  `json:"type"` // "track" or "episode"
+	Album      *album  `json:"album"`
+	Images     []image `json:"images"` // episodes carry their own art
+	Artists    []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"artists"`
+}
+
+type album struct {
+	ID          string  `json:"id"`
+	URI         string  `json:"uri"`
+	Name        string  `json:"name"`
+	AlbumType   string  `json:"album_type"` // "album", "single" or "compilation"
+	TotalTracks int     `json:"total_tracks"`
+	Images      []image `json:"images"`
+}
+
+type image struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// isAd reports whether an advertisement is playing.
+func (s *playerState) isAd() bool {
+	return s.PlayingType == "ad"
+}
+
+// artistName returns the track's primary artist, or an empty string if none is listed.
+func (t *track) artistName() string {
+	if len(t.Artists) == 0 {
+		return ""
+	}
+	return t.Artists[0].Name
+}
+
+// Low-latency mode: state fetched as soon as a state-dependent key arrives,
+// then handed to the action's first getPlayerState call
+var (
+	lowLatency bool
+	prefetch   struct {
+		sync.Mutex
+		result  chan stateResult
+		started time.Time
+	}
+)
+
+// Prefetched state older than this is discarded rather than used
+const prefetchMaxAge = 2 * time.Second
+
+type stateResult struct {
+	state *playerState
+	err   error
+}
+
+// prefetchPlayerState starts fetching the player state in the background.
+func prefetchPlayerState(client *SpotifyClient) {
+	prefetch.Lock()
+	defer prefetch.Unlock()
+
+	if prefetch.result != nil && time.Since(prefetch.started) < prefetchMaxAge {
+		return
+	}
+
+	result := make(chan stateResult, 1)
+	prefetch.result = result
+	prefetch.started = time.Now()
+	go func() {
+		state, err := fetchPlayerState(client)
+		result <- stateResult{state, err}
+	}()
+}
+
+// discardPrefetch drops a prefetched state the action didn't use, so the next
+// action can't read state from before this one's changes.
+func discardPrefetch() {
+	prefetch.Lock()
+	defer prefetch.Unlock()
+	prefetch.result = nil
+}
+
+// getPlayerState returns a fresh prefetched state if one is pending, otherwise fetches it.
+func getPlayerState(client *SpotifyClient) (*playerState, error) {
+	prefetch.Lock()
+	result := prefetch.result
+	fresh := time.Since(prefetch.started) < prefetchMaxAge
+	prefetch.result = nil
+	prefetch.Unlock()
+
+	if result != nil && fresh {
+		r := <-result
+		return r.state, r.err
+	}
+	return fetchPlayerState(client)
+}
+
+func fetchPlayerState(client *SpotifyClient) (*playerState, error) {
+	resp, err := client.get("/me/player?additional_types=episode")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 204 {
+		return nil, errNoActiveDevice
+	}
+
+	// Ad breaks can come back as 200 with an empty body
+	var state playerState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// getCurrentTrack returns the currently playing track, or nil if nothing is
+// playing. It returns errAdvertisement during ad breaks.
+func getCurrentTrack(client *SpotifyClient) (*track, error) {
+	resp, err := client.get("/me/player/currently-playing?additional_types=episode")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 204 {
+		return nil, nil
+	}
+
+	var state playerState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if state.isAd() {
+		return nil, errAdvertisement
+	}
+	return state.Item, nil
+}
+
+// Print the now-playing line after every dispatched action, toggleable at runtime
+var nowPlayingAfterAction bool
+
+func toggleNowPlayingAfterAction(_ *SpotifyClient) error {
+	nowPlayingAfterAction = !nowPlayingAfterAction
+	if nowPlayingAfterAction {
+		fmt.Println("Now playing after each action: on")
+	} else {
+		fmt.Println("Now playing after each action: off")
+	}
+	return nil
+}
+
+func printNowPlaying(client *SpotifyClient) error {
+	t, err := getCurrentTrack(client)
+	if errors.Is(err, errAdvertisement) {
+		fmt.Println("Advertisement")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		fmt.Println("Nothing playing")
+		return nil
+	}
+
+	trackShown(t.ID)
+	heart := ""
+	if saved, err := isTrackSaved(client, t.ID); err == nil && saved {
+		heart = " ♥"
+	}
+	fmt.Printf("Now playing: %s — %s%s\n", t.Name, t.artistName(), heart)
+	return nil
+}
+
+// coverImages returns the item's art: the album's for tracks, its own for episodes.
+func (t *track) coverImages() []image {
+	if t.Album != nil && len(t.Album.Images) > 0 {
+		return t.Album.Images
+	}
+	return t.Images
+}
+
+// largestImage returns the image with the most pixels.
+func largestImage(images []image) image {
+	var best image
+	for _, img := range images {
+		if img.Width*img.Height >= best.Width*best.Height {
+			best = img
+		}
+	}
+	return best
+}
+
+// printAlbumArt lists the current cover art URLs by size, largest first.
+func printAlbumArt(client *SpotifyClient) error {
+	images, err := currentCoverImages(client)
+	if err != nil {
+		return err
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Width > images[j].Width })
+	for _, img := range images {
+		fmt.Printf("  %dx%d %s\n", img.Width, img.Height, img.URL)
+	}
+	return nil
+}
+
+// openAlbumArt opens the largest cover art image in the browser.
+func openAlbumArt(client *SpotifyClient) error {
+	images, err := currentCoverImages(client)
+	if err != nil {
+		return err
+	}
+	img := largestImage(images)
+	if err := openBrowser(img.URL); err != nil {
+		return fmt.Errorf("failed to open browser: %w", err)
+	}
+	fmt.Printf("Opened cover art: %s\n", img.URL)
+	return nil
+}
+
+func currentCoverImages(client *SpotifyClient) ([]image, error) {
+	t, err := getCurrentTrack(client)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("nothing playing")
+	}
+	images := t.coverImages()
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no cover art for %s", t.Name)
+	}
+	return images, nil
+}
+
+// idFromURI returns the ID part of a Spotify URI such as spotify:track:<id>.
+func idFromURI(uri string) string {
+	return uri[strings.LastIndex(uri, ":")+1:]
+}
+
+func copyTrackID(client *SpotifyClient) error {
+	t, err := getCurrentTrack(client)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("nothing playing")
+	}
+
+	id := idFromURI(t.URI)
+	if err := copyToClipboard(id); err != nil {
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+	fmt.Printf("Copied track ID: %s\n", id)
+	return nil
+}
+
+// printProgress prints the position in the current item as elapsed / total.
+func printProgress(client *SpotifyClient) error {
+	state, err := getPlayerState(client)
+	if errors.Is(err, errNoActiveDevice) {
+		fmt.Println("Nothing playing")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if state.Item == nil || state.Item.DurationMs == 0 {
+		fmt.Println("Nothing playing")
+		return nil
+	}
+
+	fmt.Printf("%s / %s\n", formatTrackTime(state.ProgressMs), formatTrackTime(state.Item.DurationMs))
+	return nil
+}
+
+// timestampLink returns an open.spotify.com link to the track or episode that
+// starts playback at progressMs.
+func timestampLink(t *track, progressMs int) string {
+	kind := "track"
+	if t.Type == "episode" {
+		kind = "episode"
+	}
+	return fmt.Sprintf("https://open.spotify.com/%s/%s?t=%d", kind, idFromURI(t.URI), progressMs/1000)
+}
+
+// copyTimestampLink copies a link to the current moment of the playing item.
+func copyTimestampLink(client *SpotifyClient) error {
+	state, err := getPlayerState(client)
+	if err != nil {
+		return err
+	}
+	if state.Item == nil {
+		return fmt.Errorf("nothing playing")
+	}
+
+	link := timestampLink(state.Item, state.ProgressMs)
+	if err := copyToClipboard(link); err != nil {
+		return fmt.Errorf("failed to copy to clipboard: %w", err)
+	}
+	fmt.Printf("Copied link at %s: %s\n", formatTrackTime(state.ProgressMs), link)
+	return nil
+}
+
+// dumpPlayerState prints the raw player state JSON, including fields not decoded elsewhere.
+func dumpPlayerState(client *SpotifyClient) error {
+	resp, err := client.get("/me/player?additional_types=episode")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 204 {
+		fmt.Println("No active device (empty player state)")
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, body, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(pretty.String())
+	return nil
+}
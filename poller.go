@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Interval between background player state polls, 0 disables polling
+var pollInterval time.Duration
+
+// Set at runtime to stop polling without restarting
+var pollerPaused atomic.Bool
+
+func togglePoller(_ *SpotifyClient) error {
+	if pollInterval <= 0 {
+		return fmt.Errorf("polling is disabled (EZSPOTIFY_POLL_INTERVAL=0)")
+	}
+
+	paused := !pollerPaused.Load()
+	pollerPaused.Store(paused)
+	if paused {
+		fmt.Println("Background polling: paused")
+	} else {
+		fmt.Println("Background polling: resumed")
+	}
+	return nil
+}
+
+type PlayerEventType int
+
+const (
+	TrackChanged PlayerEventType = iota
+	PlayStateChanged
+	VolumeChanged
+	DeviceChanged
+	TrackRestarted // Same track started over, e.g. on repeat
+)
+
+// PlayerEvent describes a change detected between two consecutive polls.
+// State is never nil; with no active device it has no item or device ID.
+type PlayerEvent struct {
+	Type     PlayerEventType
+	State    *playerState
+	Previous *playerState
+}
+
+var (
+	subscribers   []chan PlayerEvent
+	subscribersMu sync.Mutex
+)
+
+// Events returns a new channel receiving every event the poller emits. Events
+// are dropped for a subscriber that falls behind rather than stalling the poller.
+func Events() <-chan PlayerEvent {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	ch := make(chan PlayerEvent, 16)
+	subscribers = append(subscribers, ch)
+	return ch
+}
+
+func publish(ev PlayerEvent) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	for _, ch := range subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func trackID(state *playerState) string {
+	if state.Item == nil {
+		return ""
+	}
+	return state.Item.ID
+}
+
+// diffStates returns the events describing the change from previous to current.
+func diffStates(previous, current *playerState) []PlayerEvent {
+	var events []PlayerEvent
+	emit := func(t PlayerEventType) {
+		events = append(events, PlayerEvent{Type: t, State: current, Previous: previous})
+	}
+
+	if current.Device.ID != previous.Device.ID {
+		emit(DeviceChanged)
+	}
+	if trackID(current) != trackID(previous) {
+		emit(TrackChanged)
+	} else if current.Item != nil && current.ProgressMs < previous.ProgressMs &&
+		current.ProgressMs < int(2*pollInterval/time.Millisecond) {
+		emit(TrackRestarted)
+	}
+	if current.IsPlaying != previous.IsPlaying {
+		emit(PlayStateChanged)
+	}
+	if current.Device.VolumePercent != previous.Device.VolumePercent {
+		emit(VolumeChanged)
+	}
+	return events
+}
+
+// startPoller polls the player state in the background and publishes changes as events.
+func startPoller(client *SpotifyClient) {
+	if pollInterval <= 0 {
+		return
+	}
+
+	// Re-check saved state for each new track
+	go func() {
+		for ev := range Events() {
+			if ev.Type == TrackChanged {
+				clearSavedTracks()
+			}
+		}
+	}()
+
+	go watchTrackLoops(client, Events())
+	go announceTracks(Events())
+
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		previous := &playerState{}
+		lastPoll := time.Now()
+		for now := range ticker.C {
+			if pollerPaused.Load() {
+				lastPoll = now
+				continue
+			}
+
+			state, err := fetchPlayerState(client)
+			if errors.Is(err, errNoActiveDevice) {
+				state = &playerState{}
+			} else if err != nil {
+				continue
+			}
+			// An ad break isn't a track change; compare against the track it interrupted
+			if state.isAd() {
+				lastPoll = now
+				continue
+			}
+
+			recordListening(previous, state, now.Sub(lastPoll))
+			for _, ev := range diffStates(previous, state) {
+				publish(ev)
+			}
+			previous = state
+			lastPoll = now
+		}
+	}()
+}
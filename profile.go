@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Profiles listed in EZSPOTIFY_PROFILES, each read from .env.<name> at startup.
+// The active profile's values take precedence over the environment.
+var (
+	profileNames  []string
+	profiles      = map[string]map[string]string{}
+	activeProfile string
+)
+
+// loadProfiles reads every profile in the comma-separated list and activates the
+// first one that loaded, skipping any whose file can't be read.
+func loadProfiles(value string) {
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		values, err := godotenv.Read(".env." + name)
+		if err != nil {
+			log.Printf("Failed to load profile %q: %v\n", name, err)
+			continue
+		}
+		profileNames = append(profileNames, name)
+		profiles[name] = values
+	}
+	if len(profileNames) > 0 {
+		activeProfile = profileNames[0]
+	}
+}
+
+// lookupEnv returns key from the active profile, falling back to the
+// environment and then the config file.
+func lookupEnv(key string) string {
+	if value := profiles[activeProfile][key]; value != "" {
+		return value
+	}
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return configValues[key]
+}
+
+// cycleProfile activates the next profile, re-applies its settings and moves
+// playback to its default device if one is set.
+func cycleProfile(client *SpotifyClient) error {
+	if len(profileNames) < 2 {
+		fmt.Println("No other profiles configured (set EZSPOTIFY_PROFILES)")
+		return nil
+	}
+
+	next := 0
+	for i, name := range profileNames {
+		if name == activeProfile {
+			next = (i + 1) % len(profileNames)
+			break
+		}
+	}
+	activeProfile = profileNames[next]
+
+	applySettings()
+	if focusMode.Load() {
+		allShortcuts = shortcuts
+		shortcuts = focusedShortcuts(shortcuts)
+	}
+	fmt.Printf("Profile: %s\n", activeProfile)
+
+	if defaultDevice == "" {
+		return nil
+	}
+	devices, err := findDevices(client)
+	if err != nil {
+		return err
+	}
+	device, found := findDeviceByName(devices, defaultDevice)
+	if !found {
+		fmt.Printf("Default device %s not available\n", defaultDevice)
+		return nil
+	}
+	if device.IsActive {
+		return nil
+	}
+	if err := transferPlayback(client, device); err != nil {
+		return err
+	}
+	fmt.Printf("Playing on %s\n", device.Name)
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Number of upcoming queue entries shown by showQueue
+const queuePreviewSize = 5
+
+// Spacing between consecutive queue requests to stay under rate limits
+var queueRequestDelay = 250 * time.Millisecond
+
+func addToQueue(client *SpotifyClient, uri string) error {
+	return client.post("/me/player/queue?uri="+url.QueryEscape(uri), nil)
+}
+
+// promptQueueSearch asks for a search query and queues the best matching track.
+func promptQueueSearch(client *SpotifyClient) error {
+	query, err := readLine("Search: ")
+	if errors.Is(err, errChoiceCancelled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(query) == "" {
+		return nil
+	}
+	return queueSearch(client, query)
+}
+
+// queueSearch queues the top track result for query.
+func queueSearch(client *SpotifyClient, query string) error {
+	resp, err := client.get("/search?type=track&limit=1&q=" + url.QueryEscape(query))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Tracks struct {
+			Items []track `json:"items"`
+		} `json:"tracks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if len(result.Tracks.Items) == 0 {
+		fmt.Printf("No tracks found for %q\n", query)
+		return nil
+	}
+
+	t := result.Tracks.Items[0]
+	if err := addToQueue(client, t.URI); err != nil {
+		return err
+	}
+	fmt.Printf("Queued: %s — %s\n", t.Name, t.artistName())
+	return nil
+}
+
+// getQueue returns the playing item and the tracks queued after it.
+func getQueue(client *SpotifyClient) (*track, []track, error) {
+	resp, err := client.get("/me/player/queue")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 204 {
+		return nil, nil, errNoActiveDevice
+	}
+
+	var result struct {
+		CurrentlyPlaying *track  `json:"currently_playing"`
+		Queue            []track `json:"queue"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, err
+	}
+	return result.CurrentlyPlaying, result.Queue, nil
+}
+
+// showQueue prints the next few tracks and skips forward to the one chosen by number.
+func showQueue(client *SpotifyClient) error {
+	_, queue, err := getQueue(client)
+	if err != nil {
+		return err
+	}
+	if len(queue) == 0 {
+		fmt.Println("Queue is empty")
+		return nil
+	}
+	if len(queue) > queuePreviewSize {
+		queue = queue[:queuePreviewSize]
+	}
+
+	fmt.Println("Up next:")
+	for i, t := range queue {
+		if artist := t.artistName(); artist != "" {
+			fmt.Printf("  [%d] %s — %s\n", i+1, t.Name, artist)
+		} else {
+			fmt.Printf("  [%d] %s\n", i+1, t.Name)
+		}
+	}
+
+	choice, err := readChoice(len(queue))
+	if errors.Is(err, errChoiceCancelled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < choice; i++ {
+		if err := skipToNext(client); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Skipped to: %s\n", queue[choice-1].Name)
+	return nil
+}
+
+// Tracks per album page, the most the API returns
+const albumPageSize = 50
+
+// queueAlbum adds every track of the current track's album to the queue.
+func queueAlbum(client *SpotifyClient) error {
+	t, err := getCurrentTrack(client)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return fmt.Errorf("nothing playing")
+	}
+	if t.Album == nil || t.Album.ID == "" {
+		return fmt.Errorf("%s isn't part of an album", t.Name)
+	}
+
+	// Page by offset rather than following "next", which is an absolute
+	// api.spotify.com URL that would bypass the client's base URL
+	var uris []string
+	for offset := 0; ; offset += albumPageSize {
+		resp, err := client.get(fmt.Sprintf("/albums/%s/tracks?limit=%d&offset=%d", t.Album.ID, albumPageSize, offset))
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Items []track `json:"items"`
+			Total int     `json:"total"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, item := range page.Items {
+			uris = append(uris, item.URI)
+		}
+		if len(page.Items) < albumPageSize || offset+albumPageSize >= page.Total {
+			break
+		}
+	}
+
+	for i, uri := range uris {
+		if i > 0 {
+			time.Sleep(queueRequestDelay)
+		}
+		if err := addToQueue(client, uri); err != nil {
+			return fmt.Errorf("queued %d of %d tracks: %w", i, len(uris), err)
+		}
+	}
+
+	fmt.Printf("Queued %d tracks from %s\n", len(uris), t.Album.Name)
+	return nil
+}
+
+// File written by exportQueue, from EZSPOTIFY_QUEUE_EXPORT_FILE
+var queueExportFile = "queue_snapshot.json"
+
+type queueEntry struct {
+	Name   string `json:"name"`
+	Artist string `json:"artist,omitempty"`
+	URI    string `json:"uri"`
+}
+
+func newQueueEntry(t *track) queueEntry {
+	return queueEntry{Name: t.Name, Artist: t.artistName(), URI: t.URI}
+}
+
+// exportQueue writes the playing item and upcoming queue to queueExportFile as JSON.
+func exportQueue(client *SpotifyClient) error {
+	current, queue, err := getQueue(client)
+	if err != nil {
+		return err
+	}
+	if current == nil && len(queue) == 0 {
+		fmt.Println("Queue is empty, nothing to export")
+		return nil
+	}
+
+	snapshot := struct {
+		SavedAt time.Time    `json:"saved_at"`
+		Current *queueEntry  `json:"current,omitempty"`
+		Queue   []queueEntry `json:"queue"`
+	}{SavedAt: time.Now(), Queue: []queueEntry{}}
+	if current != nil {
+		entry := newQueueEntry(current)
+		snapshot.Current = &entry
+	}
+	for i := range queue {
+		snapshot.Queue = append(snapshot.Queue, newQueueEntry(&queue[i]))
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(queueExportFile, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %d queued tracks to %s\n", len(queue), queueExportFile)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Number of plays for the repeat-track-N-times action
+var repeatTimes = 3
+
+// Progress of an active repeat-N-times run, advanced by the poller on track restarts
+var trackLoop struct {
+	sync.Mutex
+	active      bool
+	trackID     string
+	plays       int
+	restoreMode string
+}
+
+func setRepeatMode(client *SpotifyClient, mode string) error {
+	return client.put("/me/player/repeat?state="+mode, nil)
+}
+
+// Spotify's repeat modes in the order cycleRepeat steps through them
+var repeatModes = []string{"off", "context", "track"}
+
+// cycleRepeat advances the repeat mode off → context → track and back to off.
+// Choosing a mode by hand cancels any active repeat-N-times loop.
+func cycleRepeat(client *SpotifyClient) error {
+	state, err := getPlayerState(client)
+	if err != nil {
+		return err
+	}
+
+	next := repeatModes[0]
+	for i, mode := range repeatModes {
+		if mode == state.RepeatState {
+			next = repeatModes[(i+1)%len(repeatModes)]
+			break
+		}
+	}
+
+	if err := setRepeatMode(client, next); err != nil {
+		return err
+	}
+
+	trackLoop.Lock()
+	trackLoop.active = false
+	trackLoop.Unlock()
+
+	fmt.Printf("Repeat: %s\n", next)
+	return nil
+}
+
+// repeatTrackTimes loops the current track repeatTimes times, then restores the previous repeat mode.
+func repeatTrackTimes(client *SpotifyClient) error {
+	if pollInterval <= 0 {
+		return fmt.Errorf("requires background polling (EZSPOTIFY_POLL_INTERVAL)")
+	}
+	if repeatTimes < 2 {
+		return fmt.Errorf("EZSPOTIFY_REPEAT_TIMES must be at least 2")
+	}
+
+	state, err := getPlayerState(client)
+	if err != nil {
+		return err
+	}
+	if state.Item == nil {
+		return fmt.Errorf("nothing playing")
+	}
+
+	if err := setRepeatMode(client, "track"); err != nil {
+		return err
+	}
+
+	trackLoop.Lock()
+	defer trackLoop.Unlock()
+	if !trackLoop.active {
+		trackLoop.restoreMode = state.RepeatState
+		if trackLoop.restoreMode == "" || trackLoop.restoreMode == "track" {
+			trackLoop.restoreMode = "off"
+		}
+	}
+	trackLoop.active = true
+	trackLoop.trackID = state.Item.ID
+	trackLoop.plays = 1
+
+	fmt.Printf("Repeating %s: loop 1/%d\n", state.Item.Name, repeatTimes)
+	return nil
+}
+
+// watchTrackLoops counts restarts of the looping track and turns repeat off
+// once the last play has started. Changing tracks cancels the loop.
+func watchTrackLoops(client *SpotifyClient, events <-chan PlayerEvent) {
+	for ev := range events {
+		trackLoop.Lock()
+		if !trackLoop.active {
+			trackLoop.Unlock()
+			continue
+		}
+
+		switch {
+		case ev.Type == TrackChanged && trackID(ev.State) != trackLoop.trackID:
+			trackLoop.active = false
+			if err := setRepeatMode(client, trackLoop.restoreMode); err != nil {
+				log.Printf("Failed to restore repeat mode: %v\n", err)
+			}
+		case ev.Type == TrackRestarted:
+			trackLoop.plays++
+			fmt.Printf("Loop %d/%d\n", trackLoop.plays, repeatTimes)
+			if trackLoop.plays >= repeatTimes {
+				// Let the final play finish and move on
+				trackLoop.active = false
+				if err := setRepeatMode(client, trackLoop.restoreMode); err != nil {
+					log.Printf("Failed to restore repeat mode: %v\n", err)
+				}
+			}
+		}
+		trackLoop.Unlock()
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// Wall-clock gap between resume checks that counts as having slept, 0 disables the check
+var resumeGap time.Duration
+
+// Interval of the resume check
+const resumeCheckInterval = 5 * time.Second
+
+// startResumeWatcher refreshes the token and drops pooled connections after the
+// system wakes from sleep, so the first key press afterwards doesn't fail.
+func startResumeWatcher() {
+	if resumeGap <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(resumeCheckInterval)
+		defer ticker.Stop()
+
+		last := time.Now()
+		for now := range ticker.C {
+			if slept := sleptFor(last, now); slept >= resumeGap {
+				log.Printf("Resumed after %s asleep, reconnecting\n", slept.Round(time.Second))
+				reconnect()
+			}
+			last = now
+		}
+	}()
+}
+
+// sleptFor estimates how long the system was suspended between two readings.
+// The monotonic clock stops during sleep while the wall clock keeps going; a
+// ticker also fires late after a wake on systems where both keep running.
+func sleptFor(last, now time.Time) time.Duration {
+	wall := now.Round(0).Sub(last.Round(0))
+	if gap := wall - now.Sub(last); gap > 0 {
+		return gap
+	}
+	return wall - resumeCheckInterval
+}
+
+// reconnect closes connections that went stale during sleep and fetches a fresh token.
+func reconnect() {
+	// The API client's oauth2 transport sends through the default transport
+	if t, ok := http.DefaultTransport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	if err := forceTokenRefresh(); err != nil {
+		log.Printf("Token refresh after resume failed: %v\n", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Per-session usage counters, updated by runAction. They have their own lock so
+// the summary can print on exit while an action still holds actionMu.
+var (
+	sessionStart   = time.Now()
+	sessionSummary bool
+	sessionStatsMu sync.Mutex
+	actionCounts   = map[string]int{}
+	actionErrors   int
+	// Failed actions by the HTTP status of the API error behind them
+	statusErrors = map[int]int{}
+)
+
+// Exit after this long without key presses, 0 disables
+var (
+	idleExit      time.Duration
+	idleMediaKeys bool
+	idleTimer     *time.Timer // Set by startIdleTimer before any listener starts
+)
+
+func startIdleTimer(client *SpotifyClient) {
+	if idleExit <= 0 {
+		return
+	}
+	idleTimer = time.AfterFunc(idleExit, func() {
+		shutdown(client, fmt.Sprintf("No activity for %s, exiting...", idleExit))
+	})
+}
+
+func resetIdleTimer() {
+	if idleTimer != nil {
+		idleTimer.Reset(idleExit)
+	}
+}
+
+// Playback time observed by the poller this session
+var (
+	listenedTime   time.Duration
+	listenedTimeMu sync.Mutex
+)
+
+// recordListening adds the time between two polls if playback ran throughout.
+func recordListening(previous, current *playerState, elapsed time.Duration) {
+	if !previous.IsPlaying || !current.IsPlaying {
+		return
+	}
+	listenedTimeMu.Lock()
+	defer listenedTimeMu.Unlock()
+	listenedTime += elapsed
+}
+
+func printListeningTime(_ *SpotifyClient) error {
+	if pollInterval <= 0 {
+		return fmt.Errorf("requires background polling (EZSPOTIFY_POLL_INTERVAL)")
+	}
+
+	listenedTimeMu.Lock()
+	listened := listenedTime
+	listenedTimeMu.Unlock()
+
+	fmt.Printf("You've listened for %s this session.\n", formatHoursMinutes(listened))
+	return nil
+}
+
+// formatHoursMinutes renders d as "1h 12m", or just minutes under an hour.
+func formatHoursMinutes(d time.Duration) string {
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+func recordAction(name string, err error) {
+	sessionStatsMu.Lock()
+	defer sessionStatsMu.Unlock()
+
+	actionCounts[name]++
+	if err != nil {
+		actionErrors++
+	}
+	var apiErr *SpotifyError
+	if errors.As(err, &apiErr) {
+		statusErrors[apiErr.StatusCode]++
+	}
+}
+
+// printSessionSummary prints action usage for this run if EZSPOTIFY_SESSION_SUMMARY is enabled.
+func printSessionSummary() {
+	if !sessionSummary {
+		return
+	}
+
+	sessionStatsMu.Lock()
+	defer sessionStatsMu.Unlock()
+
+	fmt.Printf("Session summary (%s):\n", time.Since(sessionStart).Round(time.Second))
+	names := make([]string, 0, len(actionCounts))
+	for name := range actionCounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-24s %d\n", actions[name].Name, actionCounts[name])
+	}
+	fmt.Printf("  %-24s %d\n", "Errors", actionErrors)
+
+	statuses := make([]int, 0, len(statusErrors))
+	for status := range statusErrors {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Printf("    %-22s %d\n", fmt.Sprintf("HTTP %d", status), statusErrors[status])
+	}
+}
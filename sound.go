@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	// Ring the terminal bell once startup (including any auth) has completed
+	startupBeep bool
+	// Bell cues after every action: one ring on success, two on failure
+	soundFeedback bool
+)
+
+// beep rings the terminal bell.
+func beep() {
+	fmt.Print("\a")
+}
+
+// feedback plays the success or failure cue if EZSPOTIFY_SOUND_FEEDBACK is enabled.
+func feedback(ok bool) {
+	if !soundFeedback {
+		return
+	}
+	beep()
+	if !ok {
+		go func() {
+			// Terminals merge back-to-back bells, so space them out
+			time.Sleep(200 * time.Millisecond)
+			beep()
+		}()
+	}
+}